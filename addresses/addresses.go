@@ -0,0 +1,79 @@
+package addresses
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+// rule is what a country requires on top of name, line1, city and country.
+type rule struct {
+	region bool
+	postal *regexp.Regexp
+}
+
+var rules = map[string]rule{
+	"US": {region: true, postal: regexp.MustCompile(`^\d{5}(-\d{4})?$`)},
+	"CA": {region: true, postal: regexp.MustCompile(`^[A-Z]\d[A-Z] ?\d[A-Z]\d$`)},
+	"AU": {region: true, postal: regexp.MustCompile(`^\d{4}$`)},
+	"IN": {region: true, postal: regexp.MustCompile(`^\d{6}$`)},
+	"GB": {postal: regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? ?\d[A-Z]{2}$`)},
+	"DE": {postal: regexp.MustCompile(`^\d{5}$`)},
+	"FR": {postal: regexp.MustCompile(`^\d{5}$`)},
+	"NL": {postal: regexp.MustCompile(`^\d{4} ?[A-Z]{2}$`)},
+}
+
+var countryCode = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// Normalize trims every field and upper-cases the country and postal code.
+func Normalize(a structTypes.Address) structTypes.Address {
+	a.Label = strings.TrimSpace(a.Label)
+	a.Name = strings.TrimSpace(a.Name)
+	a.Line1 = strings.TrimSpace(a.Line1)
+	a.Line2 = strings.TrimSpace(a.Line2)
+	a.City = strings.TrimSpace(a.City)
+	a.Region = strings.TrimSpace(a.Region)
+	a.PostalCode = strings.ToUpper(strings.TrimSpace(a.PostalCode))
+	a.Country = strings.ToUpper(strings.TrimSpace(a.Country))
+	a.Phone = strings.TrimSpace(a.Phone)
+	return a
+}
+
+// Validate checks a normalized address. Name, line1, city and an ISO 3166
+// alpha-2 country are always required; countries with a known format also
+// need a region and/or a postal code that matches it.
+func Validate(a structTypes.Address) error {
+	var missing []string
+	if a.Name == "" {
+		missing = append(missing, "name")
+	}
+	if a.Line1 == "" {
+		missing = append(missing, "line1")
+	}
+	if a.City == "" {
+		missing = append(missing, "city")
+	}
+	if a.Country == "" {
+		missing = append(missing, "country")
+	}
+	r, known := rules[a.Country]
+	if r.region && a.Region == "" {
+		missing = append(missing, "region")
+	}
+	if r.postal != nil && a.PostalCode == "" {
+		missing = append(missing, "postal_code")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+
+	if !countryCode.MatchString(a.Country) {
+		return fmt.Errorf("country must be a two letter ISO code")
+	}
+	if known && r.postal != nil && !r.postal.MatchString(a.PostalCode) {
+		return fmt.Errorf("invalid postal_code %q for %s", a.PostalCode, a.Country)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/VincentSamuelPaul/production-api/addresses"
+	"github.com/VincentSamuelPaul/production-api/helpers"
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+	"github.com/gorilla/mux"
+)
+
+// ADDRESS FUNCTIONS
+
+func decodeAddress(r *http.Request) (structTypes.Address, error) {
+	var a structTypes.Address
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		return a, err
+	}
+	a = addresses.Normalize(a)
+	return a, addresses.Validate(a)
+}
+
+func (s *APIServer) handleAddresses(w http.ResponseWriter, r *http.Request) error {
+	userid, err := strconv.Atoi(mux.Vars(r)["userid"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid userid type"})
+	}
+	if r.Method == "GET" {
+		data, err := s.store.GetAddresses(r.Context(), userid)
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		return helpers.WriteJSON(w, http.StatusOK, data)
+	}
+	if r.Method == "POST" {
+		address, err := decodeAddress(r)
+		if err != nil {
+			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+		}
+		data, err := s.store.CreateAddress(r.Context(), userid, address)
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		return helpers.WriteJSON(w, http.StatusCreated, data)
+	}
+	return nil
+}
+
+func (s *APIServer) handleAddress(w http.ResponseWriter, r *http.Request) error {
+	userid, err := strconv.Atoi(mux.Vars(r)["userid"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid userid type"})
+	}
+	addressid, err := strconv.Atoi(mux.Vars(r)["addressid"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid addressid type"})
+	}
+	if r.Method == "GET" {
+		data, err := s.store.GetAddress(r.Context(), userid, addressid)
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		return helpers.WriteJSON(w, http.StatusOK, data)
+	}
+	if r.Method == "PUT" {
+		address, err := decodeAddress(r)
+		if err != nil {
+			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+		}
+		data, err := s.store.UpdateAddress(r.Context(), userid, addressid, address)
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		return helpers.WriteJSON(w, http.StatusOK, data)
+	}
+	if r.Method == "DELETE" {
+		err := s.store.DeleteAddress(r.Context(), userid, addressid)
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		return helpers.WriteJSON(w, http.StatusOK, map[string]string{"status": "address deleted"})
+	}
+	return nil
+}
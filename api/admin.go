@@ -0,0 +1,105 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/VincentSamuelPaul/production-api/helpers"
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+	"github.com/gorilla/mux"
+)
+
+// requireAdmin only lets through requests carrying the ADMIN_TOKEN in the
+// X-Admin-Token header. Admin routes are disabled when no token is set.
+func requireAdmin(f structTypes.ApiFunc) structTypes.ApiFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		token := os.Getenv("ADMIN_TOKEN")
+		given := r.Header.Get("X-Admin-Token")
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(given)) != 1 {
+			return helpers.WriteJSON(w, http.StatusForbidden, structTypes.ErrorMSG{Error: "admin access required"})
+		}
+		return f(w, r)
+	}
+}
+
+// ADMIN FUNCTIONS
+
+func (s *APIServer) handleAbandonmentReport(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	days := 30
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid days"})
+		}
+		days = n
+	}
+	since := time.Now().AddDate(0, 0, -days)
+	data, err := s.store.GetAbandonmentReport(r.Context(), since)
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return helpers.WriteJSON(w, http.StatusOK, data)
+}
+
+// handleAdminOrderStatus moves an order on by hand with {"status": ...},
+// for orders shipped or delivered outside the shipments API. Only the
+// changes in OrderTransitions are allowed.
+func (s *APIServer) handleAdminOrderStatus(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "PUT" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	orderid, err := strconv.Atoi(mux.Vars(r)["orderid"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid orderid type"})
+	}
+	var req struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return err
+	}
+	err = s.store.UpdateOrderStatus(r.Context(), orderid, req.Status)
+	var order structTypes.OrderResponse
+	if err == nil {
+		order, err = s.store.GetOrderByID(r.Context(), orderid)
+	}
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return helpers.WriteJSON(w, http.StatusOK, order)
+}
+
+// handleAdminCancelOrder cancels any order that hasn't shipped on behalf of
+// the shop, refunding it when it was paid.
+func (s *APIServer) handleAdminCancelOrder(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	orderid, err := strconv.Atoi(mux.Vars(r)["orderid"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid orderid type"})
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	order, err := s.store.GetOrderByID(r.Context(), orderid)
+	if err == nil {
+		order, err = s.cancelOrder(r.Context(), order, req.Reason, "admin")
+	}
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return helpers.WriteJSON(w, http.StatusOK, order)
+}
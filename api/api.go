@@ -1,57 +1,194 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/VincentSamuelPaul/production-api/checkout"
 	"github.com/VincentSamuelPaul/production-api/helpers"
+	"github.com/VincentSamuelPaul/production-api/moderation"
+	"github.com/VincentSamuelPaul/production-api/payments"
+	"github.com/VincentSamuelPaul/production-api/ratelimit"
+	"github.com/VincentSamuelPaul/production-api/returns"
 	structTypes "github.com/VincentSamuelPaul/production-api/types"
 	"github.com/gorilla/mux"
 )
 
+const (
+	// drainDelay gives load balancers time to observe the failing readiness
+	// probe before the listener is closed.
+	drainDelay      = 5 * time.Second
+	shutdownTimeout = 15 * time.Second
+)
+
 type APIServer struct {
-	listenAddr string
-	store      structTypes.Storage
+	listenAddr      string
+	store           structTypes.Storage
+	limits          ratelimit.Store
+	rateLimitGroups []rateLimitGroup
+	checkout        checkout.Config
+	cartMerge       structTypes.CartMergeStrategy
+	payments        payments.PaymentProvider
+	autoCapture     bool
+	idempotencyTTL  time.Duration
+	returnWindow    time.Duration
+	reviewRules     moderation.Rules
+	shuttingDown    atomic.Bool
 }
 
-func NewAPIServer(listenAddr string, store structTypes.Storage) *APIServer {
+func NewAPIServer(listenAddr string, store structTypes.Storage, limits ratelimit.Store, provider payments.PaymentProvider) *APIServer {
 	return &APIServer{
-		listenAddr: listenAddr,
-		store:      store,
+		listenAddr:      listenAddr,
+		store:           store,
+		limits:          limits,
+		rateLimitGroups: loadRateLimitGroups(),
+		checkout:        checkout.ConfigFromEnv(),
+		cartMerge:       cartMergeFromEnv(),
+		payments:        provider,
+		autoCapture:     autoCaptureFromEnv(),
+		idempotencyTTL:  idempotencyTTLFromEnv(),
+		returnWindow:    returns.WindowFromEnv(),
+		reviewRules:     moderation.RulesFromEnv(),
 	}
 }
 
 func (server *APIServer) Run() {
 	router := mux.NewRouter()
+	router.Use(tracingMiddleware)
+	router.Use(server.rateLimitMiddleware)
+	router.Use(server.idempotencyMiddleware)
 	// TEST
 	router.HandleFunc("/test", makeHTTPHandleFunc(server.handleTest))
+	// HEALTH ROUTES
+	router.HandleFunc("/healthz", makeHTTPHandleFunc(server.handleLiveness))
+	router.HandleFunc("/readyz", makeHTTPHandleFunc(server.handleReadiness))
 	// AUTH ROUTES
-	router.HandleFunc("/auth/signin", makeHTTPHandleFunc(server.handleCreateUser))
+	router.HandleFunc("/auth/signin", makeHTTPHandleFunc(server.handleSignIn))
 	router.HandleFunc("/auth/signup", makeHTTPHandleFunc(server.handleCreateUser))
 	// PRODUCT ROUTES
 	router.HandleFunc("/products", makeHTTPHandleFunc(server.handleGetAllProducts))
 	router.HandleFunc("/products/{id}", makeHTTPHandleFunc(server.handleGetProductByID))
 	// CART ROUTES
+	router.HandleFunc("/cart/guest/promotion", makeHTTPHandleFunc(server.handleGuestCartPromotion))
+	router.HandleFunc("/cart/guest", makeHTTPHandleFunc(server.handleGuestCart))
+	router.HandleFunc("/cart/guest/{productid}", makeHTTPHandleFunc(server.handleGuestCart))
+	router.HandleFunc("/cart/{userid}/promotion", makeHTTPHandleFunc(server.handleCartPromotion))
+	router.HandleFunc("/cart/{userid}/shipping", makeHTTPHandleFunc(server.handleCartShipping))
 	router.HandleFunc("/cart/{userid}", makeHTTPHandleFunc(server.handleCart))
+	router.HandleFunc("/cart/{userid}/{productid}/save-for-later", makeHTTPHandleFunc(server.handleSaveForLater))
 	router.HandleFunc("/cart/{userid}/{productid}", makeHTTPHandleFunc(server.handleCart))
+	// WISHLIST ROUTES
+	router.HandleFunc("/wishlist/shared/{token}", makeHTTPHandleFunc(server.handleSharedWishlist))
+	router.HandleFunc("/wishlist/{userid}", makeHTTPHandleFunc(server.handleWishlists))
+	router.HandleFunc("/wishlist/{userid}/{wishlistid}", makeHTTPHandleFunc(server.handleWishlist))
+	router.HandleFunc("/wishlist/{userid}/{wishlistid}/items", makeHTTPHandleFunc(server.handleWishlistItems))
+	router.HandleFunc("/wishlist/{userid}/{wishlistid}/items/{productid}", makeHTTPHandleFunc(server.handleWishlistItems))
+	router.HandleFunc("/wishlist/{userid}/{wishlistid}/items/{productid}/move-to-cart", makeHTTPHandleFunc(server.handleMoveToCart))
+	// ADDRESS ROUTES
+	router.HandleFunc("/address/{userid}", makeHTTPHandleFunc(server.handleAddresses))
+	router.HandleFunc("/address/{userid}/{addressid}", makeHTTPHandleFunc(server.handleAddress))
+	// SHIPPING ROUTES
+	router.HandleFunc("/shipping/methods", makeHTTPHandleFunc(server.handleShippingMethods))
 	// ORDER ROUTES
 	// router.HandleFunc("/order/{userid}", makeHTTPHandleFunc(server.handleOrders))
 	// router.HandleFunc("/order/{orderid}", makeHTTPHandleFunc(server.handleOrders))
 	router.HandleFunc("/order/{userid}", makeHTTPHandleFunc(server.handleOrders))
-	router.HandleFunc("/order/{userid}/{status}", makeHTTPHandleFunc(server.handleOrders))
+	router.HandleFunc("/order/{userid}/{orderid:[0-9]+}", makeHTTPHandleFunc(server.handleOrderByID)).Methods("GET")
+	router.HandleFunc("/order/{userid}/{orderid:[0-9]+}/cancel", makeHTTPHandleFunc(server.handleCancelOrder))
+	router.HandleFunc("/order/{userid}/{orderid:[0-9]+}/returns", makeHTTPHandleFunc(server.handleOrderReturns))
+	// PAYMENT ROUTES
+	router.HandleFunc("/payments/webhook", makeHTTPHandleFunc(server.handlePaymentWebhook))
 	// REVIEW ROUTES
 	router.HandleFunc("/review", makeHTTPHandleFunc(server.handleReviews))
+	router.HandleFunc("/review/report/{reviewid:[0-9]+}", makeHTTPHandleFunc(server.handleReportReview))
+	router.HandleFunc("/review/vote/{reviewid:[0-9]+}", makeHTTPHandleFunc(server.handleReviewVote))
 	router.HandleFunc("/review/{productid}", makeHTTPHandleFunc(server.handleReviews))
+	// ADMIN ROUTES
+	router.HandleFunc("/admin/promotions", makeHTTPHandleFunc(requireAdmin(server.handleAdminPromotions)))
+	router.HandleFunc("/admin/promotions/{id}", makeHTTPHandleFunc(requireAdmin(server.handleAdminPromotion)))
+	router.HandleFunc("/admin/orders/{orderid:[0-9]+}/status", makeHTTPHandleFunc(requireAdmin(server.handleAdminOrderStatus)))
+	router.HandleFunc("/admin/orders/{orderid:[0-9]+}/cancel", makeHTTPHandleFunc(requireAdmin(server.handleAdminCancelOrder)))
+	router.HandleFunc("/admin/orders/{orderid:[0-9]+}/shipments", makeHTTPHandleFunc(requireAdmin(server.handleAdminCreateShipment)))
+	router.HandleFunc("/admin/shipments/{shipmentid:[0-9]+}/delivered", makeHTTPHandleFunc(requireAdmin(server.handleAdminShipmentDelivered)))
+	router.HandleFunc("/admin/shipping-methods", makeHTTPHandleFunc(requireAdmin(server.handleAdminShippingMethods)))
+	router.HandleFunc("/admin/shipping-methods/{id}", makeHTTPHandleFunc(requireAdmin(server.handleAdminShippingMethod)))
+	router.HandleFunc("/admin/orders/{orderid:[0-9]+}/payments", makeHTTPHandleFunc(requireAdmin(server.handleAdminOrderPayments)))
+	router.HandleFunc("/admin/orders/{orderid:[0-9]+}/{action:capture|refund|void}", makeHTTPHandleFunc(requireAdmin(server.handleAdminPaymentAction)))
+	router.HandleFunc("/admin/returns", makeHTTPHandleFunc(requireAdmin(server.handleAdminReturns)))
+	router.HandleFunc("/admin/returns/{returnid:[0-9]+}", makeHTTPHandleFunc(requireAdmin(server.handleAdminReturn)))
+	router.HandleFunc("/admin/returns/{returnid:[0-9]+}/{action:approve|reject|receive|refund}", makeHTTPHandleFunc(requireAdmin(server.handleAdminReturnAction)))
+	router.HandleFunc("/admin/tax-rates", makeHTTPHandleFunc(requireAdmin(server.handleAdminTaxRates)))
+	router.HandleFunc("/admin/tax-rates/{id}", makeHTTPHandleFunc(requireAdmin(server.handleAdminTaxRate)))
+	router.HandleFunc("/admin/reviews", makeHTTPHandleFunc(requireAdmin(server.handleAdminReviews)))
+	router.HandleFunc("/admin/reviews/{reviewid:[0-9]+}/{action:approve|reject}", makeHTTPHandleFunc(requireAdmin(server.handleAdminReviewAction)))
+	router.HandleFunc("/admin/exchange-rates", makeHTTPHandleFunc(requireAdmin(server.handleAdminExchangeRates)))
+	router.HandleFunc("/admin/exchange-rates/{currency}", makeHTTPHandleFunc(requireAdmin(server.handleAdminExchangeRate)))
+	router.HandleFunc("/admin/products/{id:[0-9]+}/prices", makeHTTPHandleFunc(requireAdmin(server.handleAdminProductPrices)))
+	router.HandleFunc("/admin/products/{id:[0-9]+}/prices/{currency}", makeHTTPHandleFunc(requireAdmin(server.handleAdminProductPrice)))
+	router.HandleFunc("/admin/reports/abandoned-carts", makeHTTPHandleFunc(requireAdmin(server.handleAbandonmentReport)))
 
+	if os.Getenv("AUTH_SECRET") == "" {
+		log.Println("AUTH_SECRET is not set: sign-in issues no tokens, so reviews, votes and reports are refused")
+	}
 	log.Printf("\n\nEKIN shoes API running on: %s\n", server.listenAddr)
 
-	http.ListenAndServe(server.listenAddr, router)
+	httpServer := &http.Server{Addr: server.listenAddr, Handler: router}
+	go func() {
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	log.Println("shutting down, draining connections")
+	server.shuttingDown.Store(true)
+	time.Sleep(drainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		log.Println(err)
+	}
+}
+
+// cartMergeFromEnv reads CART_MERGE_STRATEGY (sum, max, keep_user or
+// keep_guest), defaulting to sum.
+func cartMergeFromEnv() structTypes.CartMergeStrategy {
+	switch v := structTypes.CartMergeStrategy(os.Getenv("CART_MERGE_STRATEGY")); v {
+	case structTypes.MergeSum, structTypes.MergeMax, structTypes.MergeKeepUser, structTypes.MergeKeepGuest:
+		return v
+	case "":
+	default:
+		log.Printf("CART_MERGE_STRATEGY: unknown strategy %q, using %s", v, structTypes.MergeSum)
+	}
+	return structTypes.MergeSum
+}
+
+// storeErrorStatus maps errors returned by the store to a response status.
+func storeErrorStatus(err error) int {
+	if errors.Is(err, structTypes.ErrNotFound) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, structTypes.ErrConflict) {
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
 }
 
 func makeHTTPHandleFunc(f structTypes.ApiFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := f(w, r); err != nil {
-			helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+			helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
 		}
 	}
 }
@@ -1,9 +1,16 @@
 package api
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/VincentSamuelPaul/production-api/helpers"
@@ -23,8 +30,98 @@ func (s *APIServer) handleCreateUser(w http.ResponseWriter, r *http.Request) err
 		return err
 	}
 	account.Created_at = time.Now()
-	if err := s.store.CreateUser(account); err != nil {
+	if err := s.store.CreateUser(r.Context(), account); err != nil {
 		return helpers.WriteJSON(w, http.StatusAccepted, structTypes.ErrorMSG{Error: err.Error()})
 	}
 	return helpers.WriteJSON(w, http.StatusAccepted, map[string]string{"status": "success"})
 }
+
+// handleSignIn checks the credentials and folds the visitor's guest cart,
+// if any, into the user's cart.
+func (s *APIServer) handleSignIn(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return helpers.WriteJSON(w, http.StatusForbidden, structTypes.ErrorMSG{Error: fmt.Sprintf("%s, method not allowed", r.Method)})
+	}
+	var req struct {
+		Username      string                        `json:"username"`
+		Password      string                        `json:"password"`
+		MergeStrategy structTypes.CartMergeStrategy `json:"merge_strategy"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return err
+	}
+	user, err := s.store.GetUserByUsername(r.Context(), req.Username)
+	if err != nil && !errors.Is(err, structTypes.ErrUserNotFound) {
+		return err
+	}
+	account := helpers.UserAccount(user)
+	if err != nil || !account.ValidatePassword(req.Password) {
+		return helpers.WriteJSON(w, http.StatusUnauthorized, structTypes.ErrorMSG{Error: "invalid username or password"})
+	}
+
+	merged := false
+	if token := cartToken(r); token != "" {
+		strategy := s.cartMerge
+		if req.MergeStrategy != "" {
+			strategy = req.MergeStrategy
+		}
+		err := s.store.MergeGuestCart(r.Context(), token, user.ID, strategy)
+		if err != nil && !errors.Is(err, structTypes.ErrCartNotFound) {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		merged = err == nil
+		clearCartToken(w)
+	}
+	resp := map[string]any{
+		"status":      "success",
+		"user_id":     user.ID,
+		"cart_merged": merged,
+	}
+	if secret := os.Getenv("AUTH_SECRET"); secret != "" {
+		resp["token"] = signUserToken(secret, user.ID, time.Now().Add(userTokenTTL))
+	}
+	return helpers.WriteJSON(w, http.StatusOK, resp)
+}
+
+// userTokenTTL is how long a sign-in token stays valid.
+const userTokenTTL = 30 * 24 * time.Hour
+
+func userTokenSignature(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signUserToken issues the bearer token sign-in hands out, formatted as
+// "<user id>.<expiry unix seconds>.<hex HMAC-SHA256 of the first two>".
+func signUserToken(secret string, userID int, expires time.Time) string {
+	payload := fmt.Sprintf("%d.%d", userID, expires.Unix())
+	return payload + "." + userTokenSignature(secret, payload)
+}
+
+// authenticatedUser returns the user whose token is in the Authorization
+// header. Tokens are only issued and accepted when AUTH_SECRET is set.
+func authenticatedUser(r *http.Request) (int, bool) {
+	secret := os.Getenv("AUTH_SECRET")
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if secret == "" || !ok {
+		return 0, false
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(userTokenSignature(secret, payload)), []byte(parts[2])) {
+		return 0, false
+	}
+	userID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, false
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().After(time.Unix(expires, 0)) {
+		return 0, false
+	}
+	return userID, true
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/VincentSamuelPaul/production-api/currency"
+	"github.com/VincentSamuelPaul/production-api/helpers"
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+	"github.com/gorilla/mux"
+)
+
+// converter prices responses in the given currency code, the base currency
+// when it is empty.
+func (s *APIServer) converter(ctx context.Context, code string) (currency.Converter, error) {
+	var c structTypes.Currency
+	if code != "" {
+		var err error
+		if c, err = structTypes.ParseCurrency(code); err != nil {
+			return currency.Converter{}, err
+		}
+	}
+	return currency.Load(ctx, s.store, s.checkout.Currency, c)
+}
+
+// requestConverter reads the ?currency= selector.
+func (s *APIServer) requestConverter(r *http.Request) (currency.Converter, error) {
+	return s.converter(r.Context(), r.URL.Query().Get("currency"))
+}
+
+// CURRENCY FUNCTIONS
+
+func (s *APIServer) handleAdminExchangeRates(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == "GET" {
+		data, err := s.store.GetExchangeRates(r.Context())
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		return helpers.WriteJSON(w, http.StatusOK, map[string]any{"base": s.checkout.Currency, "rates": data})
+	}
+	// POST imports a batch of rates, either a JSON list or a text/csv body
+	// of "currency,rate" lines, replacing the rates of the listed currencies
+	if r.Method == "POST" {
+		var rates []structTypes.ExchangeRate
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "text/csv" {
+			var err error
+			if rates, err = currency.ParseRates(r.Body, s.checkout.Currency); err != nil {
+				return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+			}
+		} else {
+			if err := json.NewDecoder(r.Body).Decode(&rates); err != nil {
+				return err
+			}
+			for i := range rates {
+				c, err := structTypes.ParseCurrency(string(rates[i].Currency))
+				if err == nil {
+					rates[i].Currency = c
+					err = currency.Validate(rates[i], s.checkout.Currency)
+				}
+				if err != nil {
+					return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+				}
+			}
+		}
+		if err := s.store.PutExchangeRates(r.Context(), rates); err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		return helpers.WriteJSON(w, http.StatusOK, map[string]any{"status": "exchange rates imported", "count": len(rates)})
+	}
+	return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+}
+
+func (s *APIServer) handleAdminExchangeRate(w http.ResponseWriter, r *http.Request) error {
+	c, err := structTypes.ParseCurrency(mux.Vars(r)["currency"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+	}
+	if r.Method == "PUT" {
+		rate := structTypes.ExchangeRate{Currency: c}
+		if err := json.NewDecoder(r.Body).Decode(&rate); err != nil {
+			return err
+		}
+		rate.Currency = c
+		if err := currency.Validate(rate, s.checkout.Currency); err != nil {
+			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+		}
+		if err := s.store.PutExchangeRates(r.Context(), []structTypes.ExchangeRate{rate}); err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		data, err := s.store.GetExchangeRate(r.Context(), c)
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		return helpers.WriteJSON(w, http.StatusOK, data)
+	}
+	if r.Method == "DELETE" {
+		if err := s.store.DeleteExchangeRate(r.Context(), c); err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		return helpers.WriteJSON(w, http.StatusOK, map[string]string{"status": "exchange rate deleted"})
+	}
+	return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+}
+
+// handleAdminProductPrices lists the fixed prices a product has in other
+// currencies.
+func (s *APIServer) handleAdminProductPrices(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid id type"})
+	}
+	data, err := s.store.GetProductPrices(r.Context(), id)
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return helpers.WriteJSON(w, http.StatusOK, data)
+}
+
+func (s *APIServer) handleAdminProductPrice(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid id type"})
+	}
+	c, err := structTypes.ParseCurrency(mux.Vars(r)["currency"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+	}
+	if c == s.checkout.Currency {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "the base price is the product's own price"})
+	}
+	if r.Method == "PUT" {
+		var req struct {
+			Price structTypes.Money `json:"price"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return err
+		}
+		if req.Price.Sign() < 0 {
+			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "price can't be negative"})
+		}
+		data, err := s.store.PutProductPrice(r.Context(), structTypes.ProductPrice{ProductID: id, Currency: c, Price: req.Price.Round(c)})
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		return helpers.WriteJSON(w, http.StatusOK, data)
+	}
+	if r.Method == "DELETE" {
+		if err := s.store.DeleteProductPrice(r.Context(), id, c); err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		return helpers.WriteJSON(w, http.StatusOK, map[string]string{"status": "product price deleted"})
+	}
+	return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/VincentSamuelPaul/production-api/helpers"
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+const readinessTimeout = 2 * time.Second
+
+// HEALTH FUNCTIONS
+
+// handleLiveness only reports that the process is up and serving HTTP.
+func (s *APIServer) handleLiveness(w http.ResponseWriter, r *http.Request) error {
+	return helpers.WriteJSON(w, http.StatusOK, structTypes.HealthResponse{Status: "ok"})
+}
+
+// handleReadiness reports whether this instance should receive traffic.
+func (s *APIServer) handleReadiness(w http.ResponseWriter, r *http.Request) error {
+	checks := map[string]structTypes.HealthCheck{}
+	ready := true
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+	start := time.Now()
+	if err := s.store.Ping(ctx); err != nil {
+		ready = false
+		checks["database"] = structTypes.HealthCheck{Status: "fail", Detail: err.Error()}
+	} else {
+		checks["database"] = structTypes.HealthCheck{Status: "ok", LatencyMS: time.Since(start).Milliseconds()}
+	}
+
+	if s.store.MigrationsApplied() {
+		checks["migrations"] = structTypes.HealthCheck{Status: "ok"}
+	} else {
+		ready = false
+		checks["migrations"] = structTypes.HealthCheck{Status: "fail", Detail: "schema not initialised"}
+	}
+
+	if s.shuttingDown.Load() {
+		ready = false
+		checks["shutdown"] = structTypes.HealthCheck{Status: "fail", Detail: "server is shutting down"}
+	} else {
+		checks["shutdown"] = structTypes.HealthCheck{Status: "ok"}
+	}
+
+	if !ready {
+		return helpers.WriteJSON(w, http.StatusServiceUnavailable, structTypes.HealthResponse{Status: "unavailable", Checks: checks})
+	}
+	return helpers.WriteJSON(w, http.StatusOK, structTypes.HealthResponse{Status: "ok", Checks: checks})
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/VincentSamuelPaul/production-api/helpers"
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+const (
+	idempotencyHeader      = "Idempotency-Key"
+	maxIdempotencyKey      = 255
+	maxIdempotentBody      = 1 << 20
+	defaultIdempotencyTTL  = 24 * time.Hour
+	idempotentReplayHeader = "Idempotent-Replayed"
+)
+
+// replayedHeaders are the response headers stored with a key and sent again
+// on replay. Some responses only carry what the client needs in them, like
+// the token of a guest cart the request created.
+var replayedHeaders = []string{"Content-Type", "Location", "Set-Cookie", "X-Cart-Token"}
+
+// idempotencyTTLFromEnv reads IDEMPOTENCY_KEY_TTL, how long a key is
+// remembered (default 24h).
+func idempotencyTTLFromEnv() time.Duration {
+	v := os.Getenv("IDEMPOTENCY_KEY_TTL")
+	if v == "" {
+		return defaultIdempotencyTTL
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("IDEMPOTENCY_KEY_TTL: invalid duration %q, using %s", v, defaultIdempotencyTTL)
+		return defaultIdempotencyTTL
+	}
+	return d
+}
+
+// bodyRecorder keeps a copy of the response while writing it through.
+type bodyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *bodyRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *bodyRecorder) Write(b []byte) (int, error) {
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// idempotencyMiddleware makes mutating requests that carry an
+// Idempotency-Key safe to retry. The first request with a key is served and
+// its response stored; retries with the same method, path and body get the
+// stored response back instead of running again. Reusing a key for a
+// different request, or while the first one is still running, is a 409.
+// Server errors aren't stored, so those requests can be retried for real.
+// Keys are scoped to the caller, so one client can't replay another's
+// response by guessing its key.
+func (s *APIServer) idempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" || r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Idempotency-Key is too long"})
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBody+1))
+		if err != nil {
+			helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+			return
+		}
+		// a truncated body would fingerprint, and run, a different request
+		if len(body) > maxIdempotentBody {
+			helpers.WriteJSON(w, http.StatusRequestEntityTooLarge, structTypes.ErrorMSG{Error: "request body is too large for an idempotent request"})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		sum := sha256.New()
+		io.WriteString(sum, r.Method+" "+r.URL.RequestURI()+"\n")
+		sum.Write(body)
+		fingerprint := hex.EncodeToString(sum.Sum(nil))
+		key = callerKey(r) + " " + key
+
+		record, fresh, err := s.store.BeginIdempotentRequest(r.Context(), key, fingerprint, time.Now().Add(-s.idempotencyTTL))
+		if errors.Is(err, structTypes.ErrConflict) {
+			helpers.WriteJSON(w, http.StatusConflict, structTypes.ErrorMSG{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			helpers.WriteJSON(w, http.StatusInternalServerError, structTypes.ErrorMSG{Error: err.Error()})
+			return
+		}
+		if !fresh {
+			w.Header().Set("Content-Type", "application/json")
+			for name, values := range record.Header {
+				w.Header()[name] = values
+			}
+			w.Header().Set(idempotentReplayHeader, "true")
+			w.WriteHeader(record.Status)
+			w.Write(record.Body)
+			return
+		}
+
+		rec := &bodyRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		// the request is done, don't let a client disconnect lose its result
+		ctx := context.WithoutCancel(r.Context())
+		if rec.status >= http.StatusInternalServerError {
+			err = s.store.ReleaseIdempotentRequest(ctx, key)
+		} else {
+			header := http.Header{}
+			for _, name := range replayedHeaders {
+				if values := w.Header().Values(name); len(values) > 0 {
+					header[http.CanonicalHeaderKey(name)] = values
+				}
+			}
+			err = s.store.CompleteIdempotentRequest(ctx, key, rec.status, header, rec.body.Bytes())
+		}
+		if err != nil {
+			log.Printf("idempotency key %q: %v", key, err)
+		}
+	})
+}
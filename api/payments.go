@@ -0,0 +1,146 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/VincentSamuelPaul/production-api/helpers"
+	"github.com/VincentSamuelPaul/production-api/payments"
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+	"github.com/gorilla/mux"
+)
+
+// maxWebhookBody bounds the size of a webhook delivery.
+const maxWebhookBody = 64 << 10
+
+// autoCaptureFromEnv reads PAYMENTS_AUTO_CAPTURE. Payments are captured
+// right after authorization unless it is set to false, in which case they
+// wait for an admin capture.
+func autoCaptureFromEnv() bool {
+	v, err := strconv.ParseBool(os.Getenv("PAYMENTS_AUTO_CAPTURE"))
+	return err != nil || v
+}
+
+// payOrder authorizes the order total with the provider, and captures it
+// straight away when auto capture is on.
+func (s *APIServer) payOrder(ctx context.Context, orderID int, method string) (structTypes.Payment, error) {
+	order, err := s.store.GetOrderByID(ctx, orderID)
+	if err != nil {
+		return structTypes.Payment{}, err
+	}
+	event, err := s.payments.Authorize(ctx, payments.AuthorizeRequest{
+		OrderID:  orderID,
+		Amount:   order.Total,
+		Currency: order.Currency,
+		Method:   method,
+	})
+	if err != nil {
+		return structTypes.Payment{}, err
+	}
+	payment, err := s.store.ApplyPaymentEvent(ctx, s.payments.Name(), event)
+	if err != nil || payment.Status != structTypes.PaymentAuthorized || !s.autoCapture {
+		return payment, err
+	}
+	event, err = s.payments.Capture(ctx, payment.Reference, structTypes.Money{})
+	if err != nil {
+		return payment, err
+	}
+	return s.store.ApplyPaymentEvent(ctx, s.payments.Name(), event)
+}
+
+// PAYMENT FUNCTIONS
+
+// handlePaymentWebhook applies events the provider posts back. Deliveries
+// must carry a valid signature; repeated deliveries are applied once.
+func (s *APIServer) handlePaymentWebhook(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		return err
+	}
+	if err := payments.Verify(payments.WebhookSecret(), r.Header.Get(payments.SignatureHeader), body, time.Now()); err != nil {
+		return helpers.WriteJSON(w, http.StatusUnauthorized, structTypes.ErrorMSG{Error: err.Error()})
+	}
+	var event structTypes.PaymentEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return err
+	}
+	if event.ID == "" || event.Reference == "" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "event id and reference are required"})
+	}
+	if _, err := s.store.ApplyPaymentEvent(r.Context(), s.payments.Name(), event); err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return helpers.WriteJSON(w, http.StatusOK, map[string]string{"status": "event received"})
+}
+
+// handleAdminOrderPayments lists the payments of an order.
+func (s *APIServer) handleAdminOrderPayments(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	orderid, err := strconv.Atoi(mux.Vars(r)["orderid"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid orderid type"})
+	}
+	data, err := s.store.GetPaymentsByOrderID(r.Context(), orderid)
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return helpers.WriteJSON(w, http.StatusOK, data)
+}
+
+// handleAdminPaymentAction captures, refunds or voids the latest payment of
+// an order. Capture and refund take an optional {"amount": ...}; without it
+// the whole remaining amount is used.
+func (s *APIServer) handleAdminPaymentAction(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	orderid, err := strconv.Atoi(mux.Vars(r)["orderid"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid orderid type"})
+	}
+	var req struct {
+		Amount structTypes.Money `json:"amount"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	list, err := s.store.GetPaymentsByOrderID(r.Context(), orderid)
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	if len(list) == 0 {
+		return helpers.WriteJSON(w, http.StatusNotFound, structTypes.ErrorMSG{Error: structTypes.ErrPaymentNotFound.Error()})
+	}
+	payment := list[len(list)-1]
+	// the amount is in the order's currency
+	req.Amount = req.Amount.Round(payment.Currency)
+
+	var event structTypes.PaymentEvent
+	switch mux.Vars(r)["action"] {
+	case "capture":
+		event, err = s.payments.Capture(r.Context(), payment.Reference, req.Amount)
+	case "refund":
+		event, err = s.payments.Refund(r.Context(), payment.Reference, req.Amount)
+	case "void":
+		event, err = s.payments.Void(r.Context(), payment.Reference)
+	}
+	if err == nil {
+		payment, err = s.store.ApplyPaymentEvent(r.Context(), s.payments.Name(), event)
+	}
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return helpers.WriteJSON(w, http.StatusOK, payment)
+}
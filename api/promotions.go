@@ -0,0 +1,178 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/VincentSamuelPaul/production-api/checkout"
+	"github.com/VincentSamuelPaul/production-api/currency"
+	"github.com/VincentSamuelPaul/production-api/helpers"
+	"github.com/VincentSamuelPaul/production-api/promotions"
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+	"github.com/gorilla/mux"
+)
+
+// cartSummary totals the cart with the promotion applied to it, if that
+// promotion still applies to the cart's current content, and with the tax
+// and shipping checkout would charge. The promotion is evaluated on base
+// prices and its discount converted with the rest.
+func (s *APIServer) cartSummary(ctx context.Context, cartID, userID int, conv currency.Converter) (structTypes.CartSummary, error) {
+	items, err := s.store.GetCartByID(ctx, cartID)
+	if err != nil {
+		return structTypes.CartSummary{}, err
+	}
+	promo, err := s.store.GetCartPromotion(ctx, cartID)
+	if err != nil {
+		return structTypes.CartSummary{}, err
+	}
+	var applied *structTypes.AppliedPromotion
+	var warning string
+	if promo != nil {
+		evaluated, err := s.evaluatePromotion(ctx, *promo, items, userID)
+		if errors.Is(err, promotions.ErrNotApplicable) {
+			warning = err.Error()
+		} else if err != nil {
+			return structTypes.CartSummary{}, err
+		} else {
+			applied = &evaluated
+		}
+	}
+	estimate, err := s.store.EstimateCheckout(ctx, userID, items, applied, structTypes.OrderOptions{Currency: conv.Currency})
+	if err != nil {
+		return structTypes.CartSummary{}, err
+	}
+	if applied != nil {
+		local := *applied
+		local.Discount = conv.Amount(local.Discount)
+		applied = &local
+	}
+	summary := checkout.Summarize(conv.CartItems(items), conv.Currency, applied, estimate)
+	summary.PromotionWarning = warning
+	return summary, nil
+}
+
+func (s *APIServer) evaluatePromotion(ctx context.Context, promo structTypes.Promotion, items []structTypes.CartProduct, userID int) (structTypes.AppliedPromotion, error) {
+	total, byUser, err := s.store.GetPromotionUsage(ctx, promo.ID, userID)
+	if err != nil {
+		return structTypes.AppliedPromotion{}, err
+	}
+	usage := promotions.Usage{UserID: userID, Total: total, ByUser: byUser}
+	return promotions.Evaluate(promo, promotions.CartLines(items), usage, time.Now())
+}
+
+// PROMOTION FUNCTIONS
+
+func (s *APIServer) handleCartPromotion(w http.ResponseWriter, r *http.Request) error {
+	userid, err := strconv.Atoi(mux.Vars(r)["userid"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid userid type"})
+	}
+	cartID, err := s.store.CartIDForUser(r.Context(), userid)
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return s.serveCartPromotion(w, r, cartID, userid)
+}
+
+func (s *APIServer) handleGuestCartPromotion(w http.ResponseWriter, r *http.Request) error {
+	cartID, err := s.store.CartIDForToken(r.Context(), cartToken(r))
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return s.serveCartPromotion(w, r, cartID, 0)
+}
+
+// serveCartPromotion applies a code to the cart (POST {"code": ...}) or
+// removes it (DELETE), answering with the updated cart summary.
+func (s *APIServer) serveCartPromotion(w http.ResponseWriter, r *http.Request, cartID, userID int) error {
+	if r.Method == "POST" {
+		var req struct {
+			Code string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return err
+		}
+		promo, err := s.store.GetPromotionByCode(r.Context(), req.Code)
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		items, err := s.store.GetCartByID(r.Context(), cartID)
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		if _, err := s.evaluatePromotion(r.Context(), promo, items, userID); err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		if err := s.store.ApplyCartPromotion(r.Context(), cartID, promo.ID); err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+	} else if r.Method == "DELETE" {
+		if err := s.store.RemoveCartPromotion(r.Context(), cartID); err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+	} else {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	conv, err := s.requestConverter(r)
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	data, err := s.cartSummary(r.Context(), cartID, userID, conv)
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return helpers.WriteJSON(w, http.StatusOK, data)
+}
+
+func (s *APIServer) handleAdminPromotions(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == "GET" {
+		data, err := s.store.GetPromotions(r.Context())
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		return helpers.WriteJSON(w, http.StatusOK, data)
+	}
+	if r.Method == "POST" {
+		promo := structTypes.Promotion{Active: true}
+		if err := json.NewDecoder(r.Body).Decode(&promo); err != nil {
+			return err
+		}
+		// amounts are in the base currency
+		promo.AmountOff = promo.AmountOff.Round(s.checkout.Currency)
+		promo.MinOrder = promo.MinOrder.Round(s.checkout.Currency)
+		if err := promotions.Validate(promo); err != nil {
+			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+		}
+		data, err := s.store.CreatePromotion(r.Context(), promo)
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		return helpers.WriteJSON(w, http.StatusCreated, data)
+	}
+	return nil
+}
+
+// handleAdminPromotion switches a promotion on or off with {"active": bool}.
+func (s *APIServer) handleAdminPromotion(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "PUT" && r.Method != "PATCH" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid id type"})
+	}
+	var req struct {
+		Active bool `json:"active"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return err
+	}
+	if err := s.store.SetPromotionActive(r.Context(), id, req.Active); err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return helpers.WriteJSON(w, http.StatusOK, map[string]string{"status": "promotion updated"})
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VincentSamuelPaul/production-api/helpers"
+	"github.com/VincentSamuelPaul/production-api/ratelimit"
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+type rateLimitGroup struct {
+	name   string
+	prefix string
+	limit  ratelimit.Limit
+	// writes limits only requests that change something; reads under the
+	// prefix fall through to the next group
+	writes bool
+}
+
+// loadRateLimitGroups returns the per route group limits, most specific
+// prefix first. Each can be overridden with an env var such as
+// RATE_LIMIT_AUTH=5/1m. The review limit applies to posting, voting and
+// reporting; reading reviews counts against the default limit.
+func loadRateLimitGroups() []rateLimitGroup {
+	groups := []struct {
+		name, prefix, env string
+		fallback          ratelimit.Limit
+		writes            bool
+	}{
+		{"auth", "/auth/", "RATE_LIMIT_AUTH", ratelimit.Every(5, time.Minute), false},
+		{"review", "/review", "RATE_LIMIT_REVIEW", ratelimit.Every(10, time.Minute), true},
+		{"default", "/", "RATE_LIMIT_DEFAULT", ratelimit.Every(120, time.Minute), false},
+	}
+	var loaded []rateLimitGroup
+	for _, g := range groups {
+		limit := g.fallback
+		if v := os.Getenv(g.env); v != "" {
+			parsed, err := ratelimit.Parse(v)
+			if err != nil {
+				log.Printf("%s: %v, using default", g.env, err)
+			} else {
+				limit = parsed
+			}
+		}
+		loaded = append(loaded, rateLimitGroup{name: g.name, prefix: g.prefix, limit: limit, writes: g.writes})
+	}
+	return loaded
+}
+
+var rateLimitExempt = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+func (s *APIServer) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rateLimitExempt[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		group := s.rateLimitGroupFor(r)
+		key := group.name + ":" + callerKey(r)
+
+		res, err := s.limits.Take(r.Context(), key, group.limit)
+		if err != nil {
+			// fail open, a broken limiter store shouldn't take the API down
+			log.Printf("rate limit store: %v", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(group.limit.Burst))
+		h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+		h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", group.limit.Burst, int(math.Round(float64(group.limit.Burst)/group.limit.Rate))))
+		if !res.Allowed {
+			h.Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			helpers.WriteJSON(w, http.StatusTooManyRequests, structTypes.ErrorMSG{Error: "rate limit exceeded"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *APIServer) rateLimitGroupFor(r *http.Request) rateLimitGroup {
+	read := r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS"
+	for _, g := range s.rateLimitGroups {
+		if g.writes && read {
+			continue
+		}
+		if strings.HasPrefix(r.URL.Path, g.prefix) {
+			return g
+		}
+	}
+	return s.rateLimitGroups[len(s.rateLimitGroups)-1]
+}
+
+// callerKey identifies the caller for rate limits and idempotency keys: the
+// user of a valid signed token, otherwise the client IP. The userid in the
+// path isn't used, as anyone can put any id there.
+func callerKey(r *http.Request) string {
+	if userID, ok := authenticatedUser(r); ok {
+		return "user:" + strconv.Itoa(userID)
+	}
+	return "ip:" + clientIP(r)
+}
+
+// clientIP uses X-Forwarded-For only when TRUST_PROXY_HEADERS is set, as the
+// header is trivially spoofed by clients talking to us directly.
+func clientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY_HEADERS") == "true" {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			first, _, _ := strings.Cut(fwd, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
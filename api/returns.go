@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/VincentSamuelPaul/production-api/helpers"
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+	"github.com/gorilla/mux"
+)
+
+// RETURN FUNCTIONS
+
+// handleAdminReturns lists returns, optionally only those with ?status=.
+func (s *APIServer) handleAdminReturns(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	data, err := s.store.GetReturns(r.Context(), r.URL.Query().Get("status"))
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return helpers.WriteJSON(w, http.StatusOK, data)
+}
+
+func (s *APIServer) handleAdminReturn(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	returnid, err := strconv.Atoi(mux.Vars(r)["returnid"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid returnid type"})
+	}
+	data, err := s.store.GetReturnByID(r.Context(), returnid)
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return helpers.WriteJSON(w, http.StatusOK, data)
+}
+
+// handleAdminReturnAction moves a return along its workflow:
+//
+//	approve, reject  {"note": ...}
+//	receive          {"conditions": {"<return item id>": "resellable" | "damaged"}}
+//	refund           refunds the return's amount to the order's payment
+func (s *APIServer) handleAdminReturnAction(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	returnid, err := strconv.Atoi(mux.Vars(r)["returnid"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid returnid type"})
+	}
+	var req struct {
+		Note       string         `json:"note"`
+		Conditions map[int]string `json:"conditions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	var data structTypes.Return
+	switch mux.Vars(r)["action"] {
+	case "approve":
+		data, err = s.store.DecideReturn(r.Context(), returnid, true, req.Note, "admin")
+	case "reject":
+		data, err = s.store.DecideReturn(r.Context(), returnid, false, req.Note, "admin")
+	case "receive":
+		data, err = s.store.ReceiveReturn(r.Context(), returnid, req.Conditions)
+	case "refund":
+		data, err = s.refundReturn(r.Context(), returnid)
+	}
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return helpers.WriteJSON(w, http.StatusOK, data)
+}
+
+// refundReturn pays the return's amount back through the order's captured
+// payment, never more than what is left of it, and closes the return.
+func (s *APIServer) refundReturn(ctx context.Context, returnID int) (structTypes.Return, error) {
+	ret, err := s.store.GetReturnByID(ctx, returnID)
+	if err != nil {
+		return ret, err
+	}
+	if ret.Status != structTypes.ReturnReceived {
+		return ret, fmt.Errorf("%w: return %d is %s", structTypes.ErrReturnNotAllowed, returnID, ret.Status)
+	}
+	list, err := s.store.GetPaymentsByOrderID(ctx, ret.OrderID)
+	if err != nil {
+		return ret, err
+	}
+	var payment *structTypes.Payment
+	for i := range list {
+		if list[i].Status == structTypes.PaymentCaptured {
+			payment = &list[i]
+		}
+	}
+	if payment == nil {
+		return ret, fmt.Errorf("%w: no captured payment for order %d", structTypes.ErrPaymentNotFound, ret.OrderID)
+	}
+
+	amount := ret.RefundAmount
+	amount = amount.Min(payment.CapturedAmount.Sub(payment.RefundedAmount))
+	if amount.Sign() > 0 {
+		event, err := s.payments.Refund(ctx, payment.Reference, amount)
+		if err != nil {
+			return ret, err
+		}
+		if _, err := s.store.ApplyPaymentEvent(ctx, s.payments.Name(), event); err != nil {
+			return ret, err
+		}
+	}
+	return s.store.MarkReturnRefunded(ctx, returnID, amount)
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/VincentSamuelPaul/production-api/helpers"
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultReviewPageSize = 20
+	maxReviewPageSize     = 100
+)
+
+// REVIEW FUNCTIONS
+
+// handleReviewVote records whether a signed-in customer found a review
+// helpful (POST {"helpful": true|false}) or takes the vote back (DELETE).
+// Each customer has one vote per review.
+func (s *APIServer) handleReviewVote(w http.ResponseWriter, r *http.Request) error {
+	userID, ok := authenticatedUser(r)
+	if !ok {
+		return helpers.WriteJSON(w, http.StatusUnauthorized, structTypes.ErrorMSG{Error: "sign in required"})
+	}
+	reviewid, err := strconv.Atoi(mux.Vars(r)["reviewid"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid reviewid type"})
+	}
+	var data structTypes.ReviewResponse
+	switch r.Method {
+	case "POST":
+		var req struct {
+			Helpful *bool `json:"helpful"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return err
+		}
+		if req.Helpful == nil {
+			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "helpful is required"})
+		}
+		data, err = s.store.VoteReview(r.Context(), reviewid, userID, *req.Helpful)
+	case "DELETE":
+		data, err = s.store.RemoveReviewVote(r.Context(), reviewid, userID)
+	default:
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return helpers.WriteJSON(w, http.StatusOK, data)
+}
+
+// REVIEW MODERATION FUNCTIONS
+
+// handleReportReview lets a signed-in customer report a published review.
+// Enough reports take the review down until a moderator decides.
+func (s *APIServer) handleReportReview(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	userID, ok := authenticatedUser(r)
+	if !ok {
+		return helpers.WriteJSON(w, http.StatusUnauthorized, structTypes.ErrorMSG{Error: "sign in required"})
+	}
+	reviewid, err := strconv.Atoi(mux.Vars(r)["reviewid"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid reviewid type"})
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if err := s.store.ReportReview(r.Context(), reviewid, userID, req.Reason, s.reviewRules.ReportThreshold); err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return helpers.WriteJSON(w, http.StatusOK, map[string]string{"status": "review reported"})
+}
+
+// handleAdminReviews lists the moderation queue, or the reviews in
+// ?status= when given.
+func (s *APIServer) handleAdminReviews(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", structTypes.ReviewPending, structTypes.ReviewApproved, structTypes.ReviewRejected, structTypes.ReviewFlagged:
+	default:
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid status"})
+	}
+	data, err := s.store.GetReviewsForModeration(r.Context(), status)
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return helpers.WriteJSON(w, http.StatusOK, data)
+}
+
+func (s *APIServer) handleAdminReviewAction(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	reviewid, err := strconv.Atoi(mux.Vars(r)["reviewid"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid reviewid type"})
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	approve := mux.Vars(r)["action"] == "approve"
+	if !approve && req.Reason == "" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "a reason is required to reject a review"})
+	}
+	data, err := s.store.ModerateReview(r.Context(), reviewid, approve, req.Reason)
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return helpers.WriteJSON(w, http.StatusOK, data)
+}
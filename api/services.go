@@ -1,9 +1,16 @@
 package api
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/VincentSamuelPaul/production-api/helpers"
 	structTypes "github.com/VincentSamuelPaul/production-api/types"
@@ -16,13 +23,42 @@ func (s *APIServer) handleTest(w http.ResponseWriter, r *http.Request) error {
 
 // PRODUCT FUNCTIONS
 
+// handleGetAllProducts lists products, ordered by ?sort= (id, price, newest,
+// rating or reviews) and ?order=asc|desc, and narrowed by ?min_rating=.
+// Rating and review sorts put the best rated first unless asked otherwise.
 func (s *APIServer) handleGetAllProducts(w http.ResponseWriter, r *http.Request) error {
 	if r.Method != "GET" {
 		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
 	}
-	data, err := s.store.GetAllProducts()
+	query := r.URL.Query()
+	filter := structTypes.ProductFilter{Sort: query.Get("sort")}
+	filter.Desc = filter.Sort == "rating" || filter.Sort == "reviews"
+	switch query.Get("order") {
+	case "asc":
+		filter.Desc = false
+	case "desc":
+		filter.Desc = true
+	case "":
+	default:
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid order"})
+	}
+	if v := query.Get("min_rating"); v != "" {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || n < 0 || n > 5 {
+			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid min_rating"})
+		}
+		filter.MinRating = n
+	}
+	conv, err := s.requestConverter(r)
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	data, err := s.store.GetAllProducts(r.Context(), filter)
 	if err != nil {
-		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	for i := range data {
+		data[i] = conv.Product(data[i])
 	}
 	return helpers.WriteJSON(w, http.StatusOK, data)
 }
@@ -36,25 +72,95 @@ func (s *APIServer) handleGetProductByID(w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid id type"})
 	}
-	data, err := s.store.GetProductByID(id)
+	conv, err := s.requestConverter(r)
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	data, err := s.store.GetProductByID(r.Context(), id)
 	if err != nil {
-		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
 	}
+	data = conv.Product(data)
 	return helpers.WriteJSON(w, http.StatusOK, data)
 }
 
 // CART FUNCTIONS
 
+const cartTokenCookie = "cart_token"
+
 func (s *APIServer) handleCart(w http.ResponseWriter, r *http.Request) error {
 	idStr := mux.Vars(r)["userid"]
 	userid, err := strconv.Atoi(idStr)
 	if err != nil {
 		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid id type"})
 	}
+	cartID, err := s.store.CartIDForUser(r.Context(), userid)
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return s.serveCart(w, r, cartID, userid)
+}
+
+// handleGuestCart serves the cart of an anonymous visitor, identified by the
+// cart_token cookie or X-Cart-Token header. Adding to a cart without a token
+// opens a new guest cart and hands its token back in both places.
+func (s *APIServer) handleGuestCart(w http.ResponseWriter, r *http.Request) error {
+	token := cartToken(r)
+	if token == "" {
+		if r.Method != "POST" && r.Method != "PUT" && r.Method != "PATCH" {
+			return helpers.WriteJSON(w, http.StatusNotFound, structTypes.ErrorMSG{Error: structTypes.ErrCartNotFound.Error()})
+		}
+		cartID, token, err := s.store.CreateGuestCart(r.Context())
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		setCartToken(w, token)
+		return s.serveCart(w, r, cartID, 0)
+	}
+	cartID, err := s.store.CartIDForToken(r.Context(), token)
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return s.serveCart(w, r, cartID, 0)
+}
+
+func cartToken(r *http.Request) string {
+	if token := r.Header.Get("X-Cart-Token"); token != "" {
+		return token
+	}
+	if c, err := r.Cookie(cartTokenCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+func setCartToken(w http.ResponseWriter, token string) {
+	w.Header().Set("X-Cart-Token", token)
+	http.SetCookie(w, &http.Cookie{
+		Name:     cartTokenCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int((30 * 24 * time.Hour).Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearCartToken(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: cartTokenCookie, Value: "", Path: "/", MaxAge: -1})
+}
+
+// serveCart handles the cart operations once the cart is known. userID is 0
+// for guest carts.
+func (s *APIServer) serveCart(w http.ResponseWriter, r *http.Request, cartID, userID int) error {
 	if r.Method == "GET" {
-		data, err := s.store.GetCartByID(userid)
+		conv, err := s.requestConverter(r)
 		if err != nil {
-			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		data, err := s.cartSummary(r.Context(), cartID, userID, conv)
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
 		}
 		return helpers.WriteJSON(w, http.StatusOK, data)
 	}
@@ -66,11 +172,37 @@ func (s *APIServer) handleCart(w http.ResponseWriter, r *http.Request) error {
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			return err
 		}
-		err := s.store.AddToCart(userid, req.ProductID, req.Quantity)
+		if req.Quantity <= 0 {
+			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "quantity must be positive"})
+		}
+		quantity, err := s.store.AddToCart(r.Context(), cartID, req.ProductID, req.Quantity)
 		if err != nil {
-			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
 		}
-		return helpers.WriteJSON(w, http.StatusAccepted, map[string]string{"status": "added to cart"})
+		return helpers.WriteJSON(w, http.StatusAccepted, map[string]any{"status": "added to cart", "quantity": quantity})
+	}
+	if r.Method == "PUT" || r.Method == "PATCH" {
+		productid, err := strconv.Atoi(mux.Vars(r)["productid"])
+		if err != nil {
+			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid productid type"})
+		}
+		var req struct {
+			Quantity *int `json:"quantity"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return err
+		}
+		if req.Quantity == nil || *req.Quantity < 0 {
+			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "quantity must be zero or positive"})
+		}
+		quantity, err := s.store.SetCartItemQuantity(r.Context(), cartID, productid, *req.Quantity)
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		if quantity == 0 {
+			return helpers.WriteJSON(w, http.StatusAccepted, map[string]string{"status": "item removed from cart"})
+		}
+		return helpers.WriteJSON(w, http.StatusAccepted, map[string]any{"status": "cart updated", "quantity": quantity})
 	}
 	if r.Method == "DELETE" {
 		idStr := mux.Vars(r)["productid"]
@@ -79,15 +211,15 @@ func (s *APIServer) handleCart(w http.ResponseWriter, r *http.Request) error {
 			if err != nil {
 				return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid id type"})
 			}
-			err = s.store.DeleteFromCart(userid, productid)
+			err = s.store.DeleteFromCart(r.Context(), cartID, productid)
 			if err != nil {
-				return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+				return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
 			}
 			return helpers.WriteJSON(w, http.StatusAccepted, map[string]string{"status": "item removed from cart"})
 		} else {
-			err := s.store.EmptyCart(userid)
+			err := s.store.EmptyCart(r.Context(), cartID)
 			if err != nil {
-				return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+				return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
 			}
 			return helpers.WriteJSON(w, http.StatusAccepted, map[string]string{"status": "cart empty"})
 		}
@@ -105,70 +237,275 @@ func (s *APIServer) handleOrders(w http.ResponseWriter, r *http.Request) error {
 		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid userid type"})
 	}
 
-	status := mux.Vars(r)["status"]
-
 	if r.Method == "GET" {
-		data, err := s.store.GetAllOrdersByUserID(userid)
+		data, err := s.store.GetAllOrdersByUserID(r.Context(), userid)
 		if err != nil {
-			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
 		}
 		return helpers.WriteJSON(w, http.StatusOK, data)
 	}
 
-	// if r.Method == "GET" && orderStr != "" && idStr == "" {
-	// 	orderid, err := strconv.Atoi(orderStr)
-	// 	if err != nil {
-	// 		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid orderid type"})
-	// 	}
-	// 	data, err := s.store.GetOrderByID(orderid)
-	// 	if err != nil {
-	// 		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
-	// 	}
-	// 	return helpers.WriteJSON(w, http.StatusOK, data)
-	// }
-
 	if r.Method == "POST" {
-		var orders []structTypes.OrderRequest
-		if err := json.NewDecoder(r.Body).Decode(&orders); err != nil {
+		// either a bare list of items or {"items": [...], "payment_method": "...",
+		// "shipping_address_id": ..., "billing_address_id": ..., "shipping_method": "...",
+		// "currency": "..."}; ?currency= selects the currency for a bare list
+		var req struct {
+			Items         []structTypes.OrderRequest `json:"items"`
+			PaymentMethod string                     `json:"payment_method"`
+			structTypes.OrderOptions
+		}
+		var body json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 			return err
 		}
-		err := s.store.CreateOrder(userid, orders)
+		if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+			err = json.Unmarshal(body, &req.Items)
+		} else {
+			err = json.Unmarshal(body, &req)
+		}
+		if err != nil {
+			return err
+		}
+		if req.Currency == "" {
+			req.Currency = structTypes.Currency(r.URL.Query().Get("currency"))
+		}
+		if req.Currency != "" {
+			if req.Currency, err = structTypes.ParseCurrency(string(req.Currency)); err != nil {
+				return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+			}
+		}
+		orderID, err := s.store.CreateOrder(r.Context(), userid, req.Items, req.OrderOptions)
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		payment, err := s.payOrder(r.Context(), orderID, req.PaymentMethod)
 		if err != nil {
-			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+			return helpers.WriteJSON(w, http.StatusBadGateway, map[string]any{"error": err.Error(), "order_id": orderID})
 		}
-		return helpers.WriteJSON(w, http.StatusOK, map[string]string{"status": "orders placed"})
+		if payment.Status == structTypes.PaymentFailed {
+			return helpers.WriteJSON(w, http.StatusPaymentRequired, map[string]any{"error": "payment failed: " + payment.FailureReason, "order_id": orderID, "payment": payment})
+		}
+		return helpers.WriteJSON(w, http.StatusOK, map[string]any{"status": "orders placed", "order_id": orderID, "payment": payment})
 	}
 
-	if r.Method == "PUT" {
-		err := s.store.UpdateOrderStatus(userid, status)
+	return nil
+}
+
+// handleOrderByID returns one order of the user. Orders of other users are
+// reported as missing rather than forbidden so IDs can't be probed.
+func (s *APIServer) handleOrderByID(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	userid, err := strconv.Atoi(mux.Vars(r)["userid"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid userid type"})
+	}
+	orderid, err := strconv.Atoi(mux.Vars(r)["orderid"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid orderid type"})
+	}
+	data, err := s.store.GetOrderByID(r.Context(), orderid)
+	if err == nil && data.UserID != userid {
+		err = fmt.Errorf("%w: id %d", structTypes.ErrOrderNotFound, orderid)
+	}
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return helpers.WriteJSON(w, http.StatusOK, data)
+}
+
+// handleCancelOrder lets a user cancel one of their orders that hasn't
+// shipped yet, with an optional {"reason": ...}. Paid orders are refunded.
+func (s *APIServer) handleCancelOrder(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	userid, err := strconv.Atoi(mux.Vars(r)["userid"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid userid type"})
+	}
+	orderid, err := strconv.Atoi(mux.Vars(r)["orderid"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid orderid type"})
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	// the path's userid is anyone's to claim, so only a signed-in caller is
+	// recorded by ID
+	actor := "customer"
+	if signedIn, ok := authenticatedUser(r); ok {
+		if signedIn != userid {
+			err := fmt.Errorf("%w: id %d", structTypes.ErrOrderNotFound, orderid)
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		actor = fmt.Sprintf("user:%d", signedIn)
+	}
+	order, err := s.store.GetOrderByID(r.Context(), orderid)
+	if err == nil && order.UserID != userid {
+		err = fmt.Errorf("%w: id %d", structTypes.ErrOrderNotFound, orderid)
+	}
+	if err == nil {
+		order, err = s.cancelOrder(r.Context(), order, req.Reason, actor)
+	}
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return helpers.WriteJSON(w, http.StatusOK, order)
+}
+
+// handleOrderReturns lists the returns of one of the user's orders (GET) or
+// opens a new one for some of its items (POST).
+func (s *APIServer) handleOrderReturns(w http.ResponseWriter, r *http.Request) error {
+	userid, err := strconv.Atoi(mux.Vars(r)["userid"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid userid type"})
+	}
+	orderid, err := strconv.Atoi(mux.Vars(r)["orderid"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid orderid type"})
+	}
+
+	if r.Method == "GET" {
+		order, err := s.store.GetOrderByID(r.Context(), orderid)
+		if err == nil && order.UserID != userid {
+			err = fmt.Errorf("%w: id %d", structTypes.ErrOrderNotFound, orderid)
+		}
 		if err != nil {
-			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
 		}
-		return helpers.WriteJSON(w, http.StatusOK, map[string]string{"status": "orders status updated"})
+		data, err := s.store.GetReturnsByOrderID(r.Context(), orderid)
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		return helpers.WriteJSON(w, http.StatusOK, data)
 	}
 
-	if r.Method == "DELETE" {
-		err = s.store.DeleteOrder(userid)
+	if r.Method == "POST" {
+		var req structTypes.ReturnRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return err
+		}
+		data, err := s.store.CreateReturn(r.Context(), userid, orderid, req, time.Now().Add(-s.returnWindow))
 		if err != nil {
-			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
 		}
-		return helpers.WriteJSON(w, http.StatusOK, map[string]string{"status": "order deleted"})
+		return helpers.WriteJSON(w, http.StatusCreated, data)
 	}
 
-	return nil
+	return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
 }
 
+// cancelOrder cancels the order and returns it as it is now. A paid order
+// is refunded through the provider first, and is only cancelled, putting
+// its stock back, once the refund went through. A payment authorized for
+// the order is voided after cancelling; a failed void is only logged, as
+// the order stays cancelled and the authorization lapses at the provider.
+func (s *APIServer) cancelOrder(ctx context.Context, order structTypes.OrderResponse, reason, actor string) (structTypes.OrderResponse, error) {
+	if !structTypes.OrderCancellable(order.Status) {
+		return order, fmt.Errorf("%w: order %d is %s", structTypes.ErrOrderNotCancellable, order.ID, order.Status)
+	}
+	if order.Status == structTypes.OrderPaid {
+		list, err := s.store.GetPaymentsByOrderID(ctx, order.ID)
+		if err != nil {
+			return order, err
+		}
+		for _, payment := range list {
+			if payment.Status != structTypes.PaymentCaptured {
+				continue
+			}
+			event, err := s.payments.Refund(ctx, payment.Reference, structTypes.Money{})
+			if err == nil {
+				_, err = s.store.ApplyPaymentEvent(ctx, s.payments.Name(), event)
+			}
+			if err != nil {
+				return order, fmt.Errorf("refund payment %s: %w", payment.Reference, err)
+			}
+		}
+	}
+	if err := s.store.CancelOrder(ctx, order.ID, reason, actor); err != nil {
+		return order, err
+	}
+	if order.Status == structTypes.OrderAuthorized {
+		list, err := s.store.GetPaymentsByOrderID(ctx, order.ID)
+		if err != nil {
+			return order, err
+		}
+		for _, payment := range list {
+			if payment.Status != structTypes.PaymentAuthorized {
+				continue
+			}
+			event, err := s.payments.Void(ctx, payment.Reference)
+			if err == nil {
+				_, err = s.store.ApplyPaymentEvent(ctx, s.payments.Name(), event)
+			}
+			if err != nil {
+				log.Printf("order %d: void payment %s: %v", order.ID, payment.Reference, err)
+			}
+		}
+	}
+	return s.store.GetOrderByID(ctx, order.ID)
+}
+
+// handleReviews lists a product's reviews. Writing, editing and deleting a
+// review needs a sign-in token and only ever touches the caller's own
+// review.
 func (s *APIServer) handleReviews(w http.ResponseWriter, r *http.Request) error {
-	if r.Method == "POST" {
+	if r.Method == "POST" || r.Method == "PUT" || r.Method == "DELETE" {
+		userID, ok := authenticatedUser(r)
+		if !ok {
+			return helpers.WriteJSON(w, http.StatusUnauthorized, structTypes.ErrorMSG{Error: "sign in required"})
+		}
 		var review structTypes.ReviewRequest
-		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
-			return err
+		if r.Method != "DELETE" {
+			if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+				return err
+			}
+			if review.Rating < 1 || review.Rating > 5 {
+				return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "rating must be between 1 and 5"})
+			}
+			if err := s.reviewRules.Check(review.Comment); err != nil {
+				return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+			}
+			review.Status, review.ModerationNote = s.reviewRules.Screen(review.Comment)
+		}
+		review.UserID = userID
+		if str, ok := mux.Vars(r)["productid"]; ok {
+			productID, err := strconv.Atoi(str)
+			if err != nil {
+				return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid productid type"})
+			}
+			review.ProductID = productID
+		}
+
+		var err error
+		status := "review updated"
+		switch r.Method {
+		case "POST":
+			var created bool
+			created, err = s.store.CreateNewReview(r.Context(), review)
+			if created {
+				status = "review added"
+			}
+		case "PUT":
+			err = s.store.UpdateReview(r.Context(), review)
+		case "DELETE":
+			err = s.store.DeleteReview(r.Context(), review.UserID, review.ProductID)
+			status = "review deleted"
 		}
-		err := s.store.CreateNewReview(review)
 		if err != nil {
-			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		resp := map[string]string{"status": status}
+		if review.Status != "" {
+			// pending and flagged reviews stay hidden until a moderator approves them
+			resp["review_status"] = review.Status
 		}
-		return helpers.WriteJSON(w, http.StatusOK, map[string]string{"status": "review added"})
+		return helpers.WriteJSON(w, http.StatusOK, resp)
 	}
 
 	if r.Method == "GET" {
@@ -177,9 +514,21 @@ func (s *APIServer) handleReviews(w http.ResponseWriter, r *http.Request) error
 		if err != nil {
 			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid productid type"})
 		}
-		data, err := s.store.GetAllReviewsByProductID(prodcutID)
+		// ?sort=newest|highest|lowest|helpful, ?page= from 1, ?page_size= up to 100
+		query := structTypes.ReviewQuery{ProductID: prodcutID, Sort: r.URL.Query().Get("sort"), Page: 1, PageSize: defaultReviewPageSize}
+		if v := r.URL.Query().Get("page"); v != "" {
+			if query.Page, err = strconv.Atoi(v); err != nil || query.Page < 1 {
+				return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid page"})
+			}
+		}
+		if v := r.URL.Query().Get("page_size"); v != "" {
+			if query.PageSize, err = strconv.Atoi(v); err != nil || query.PageSize < 1 || query.PageSize > maxReviewPageSize {
+				return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid page_size"})
+			}
+		}
+		data, err := s.store.GetAllReviewsByProductID(r.Context(), query)
 		if err != nil {
-			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
 		}
 		return helpers.WriteJSON(w, http.StatusOK, data)
 	}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/VincentSamuelPaul/production-api/currency"
+	"github.com/VincentSamuelPaul/production-api/helpers"
+	"github.com/VincentSamuelPaul/production-api/shipping"
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+	"github.com/gorilla/mux"
+)
+
+// SHIPPING FUNCTIONS
+
+func (s *APIServer) handleShippingMethods(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	data, err := s.store.GetShippingMethods(r.Context(), true)
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return helpers.WriteJSON(w, http.StatusOK, data)
+}
+
+// handleCartShipping quotes every shipping method for the user's cart,
+// shipped to ?address_id= or else the default shipping address.
+func (s *APIServer) handleCartShipping(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	userid, err := strconv.Atoi(mux.Vars(r)["userid"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid userid type"})
+	}
+
+	var address structTypes.Address
+	if v := r.URL.Query().Get("address_id"); v != "" {
+		addressid, err := strconv.Atoi(v)
+		if err != nil {
+			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid address_id type"})
+		}
+		address, err = s.store.GetAddress(r.Context(), userid, addressid)
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+	} else {
+		list, err := s.store.GetAddresses(r.Context(), userid)
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		for _, a := range list {
+			if a.DefaultShipping {
+				address = a
+			}
+		}
+		if address.ID == 0 {
+			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: structTypes.ErrShippingAddressRequired.Error()})
+		}
+	}
+
+	cartID, err := s.store.CartIDForUser(r.Context(), userid)
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	conv, err := s.requestConverter(r)
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	// rates and free shipping thresholds are in the base currency, so the
+	// cart is quoted in it and only the costs are converted
+	summary, err := s.cartSummary(r.Context(), cartID, userid, currency.Identity(s.checkout.Currency))
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	methods, err := s.store.GetShippingMethods(r.Context(), true)
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	quotes := shipping.Quotes(methods, address.Country, shipping.Weight(summary.Items), summary.Subtotal.Sub(summary.Discount))
+	for i := range quotes {
+		quotes[i].Cost = conv.Amount(quotes[i].Cost)
+		if summary.Promotion != nil && summary.Promotion.FreeShipping {
+			quotes[i].Cost = structTypes.NewMoney(0, conv.Currency)
+		}
+	}
+	return helpers.WriteJSON(w, http.StatusOK, quotes)
+}
+
+func (s *APIServer) handleAdminShippingMethods(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == "GET" {
+		data, err := s.store.GetShippingMethods(r.Context(), false)
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		return helpers.WriteJSON(w, http.StatusOK, data)
+	}
+	if r.Method == "POST" {
+		method := structTypes.ShippingMethod{Active: true}
+		if err := json.NewDecoder(r.Body).Decode(&method); err != nil {
+			return err
+		}
+		method.Code = strings.ToLower(strings.TrimSpace(method.Code))
+		// rates are in the base currency
+		method.FreeOver = method.FreeOver.Round(s.checkout.Currency)
+		for i := range method.Rates {
+			method.Rates[i].Base = method.Rates[i].Base.Round(s.checkout.Currency)
+			method.Rates[i].PerKg = method.Rates[i].PerKg.Round(s.checkout.Currency)
+			for j, country := range method.Rates[i].Countries {
+				method.Rates[i].Countries[j] = strings.ToUpper(strings.TrimSpace(country))
+			}
+		}
+		if err := shipping.Validate(method); err != nil {
+			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+		}
+		data, err := s.store.CreateShippingMethod(r.Context(), method)
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		return helpers.WriteJSON(w, http.StatusCreated, data)
+	}
+	return nil
+}
+
+// handleAdminShippingMethod switches a method on or off with {"active": bool}.
+func (s *APIServer) handleAdminShippingMethod(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "PUT" && r.Method != "PATCH" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid id type"})
+	}
+	var req struct {
+		Active bool `json:"active"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return err
+	}
+	if err := s.store.SetShippingMethodActive(r.Context(), id, req.Active); err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return helpers.WriteJSON(w, http.StatusOK, map[string]string{"status": "shipping method updated"})
+}
+
+// handleAdminCreateShipment ships a paid order with
+// {"carrier": ..., "tracking_number": ...}.
+func (s *APIServer) handleAdminCreateShipment(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	orderid, err := strconv.Atoi(mux.Vars(r)["orderid"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid orderid type"})
+	}
+	var req struct {
+		Carrier        string `json:"carrier"`
+		TrackingNumber string `json:"tracking_number"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return err
+	}
+	req.Carrier, req.TrackingNumber = strings.TrimSpace(req.Carrier), strings.TrimSpace(req.TrackingNumber)
+	if req.Carrier == "" || req.TrackingNumber == "" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "carrier and tracking_number are required"})
+	}
+	data, err := s.store.CreateShipment(r.Context(), orderid, req.Carrier, req.TrackingNumber)
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return helpers.WriteJSON(w, http.StatusCreated, data)
+}
+
+func (s *APIServer) handleAdminShipmentDelivered(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	shipmentid, err := strconv.Atoi(mux.Vars(r)["shipmentid"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid shipmentid type"})
+	}
+	data, err := s.store.MarkShipmentDelivered(r.Context(), shipmentid)
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return helpers.WriteJSON(w, http.StatusOK, data)
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/VincentSamuelPaul/production-api/helpers"
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+	"github.com/gorilla/mux"
+)
+
+// TAX FUNCTIONS
+
+func (s *APIServer) handleAdminTaxRates(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == "GET" {
+		data, err := s.store.GetTaxRates(r.Context())
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		return helpers.WriteJSON(w, http.StatusOK, data)
+	}
+	// POST creates the rate or replaces the one for the same jurisdiction
+	// and class
+	if r.Method == "POST" || r.Method == "PUT" {
+		var rate structTypes.TaxRate
+		if err := json.NewDecoder(r.Body).Decode(&rate); err != nil {
+			return err
+		}
+		rate.Country = strings.ToUpper(strings.TrimSpace(rate.Country))
+		rate.Region = strings.ToUpper(strings.TrimSpace(rate.Region))
+		rate.TaxClass = strings.ToLower(strings.TrimSpace(rate.TaxClass))
+		if len(rate.Country) != 2 {
+			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "country must be a two letter ISO code"})
+		}
+		if rate.RateBasisPoints < 0 || rate.RateBasisPoints > 10000 {
+			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "rate_bps must be between 0 and 10000"})
+		}
+		data, err := s.store.PutTaxRate(r.Context(), rate)
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		return helpers.WriteJSON(w, http.StatusOK, data)
+	}
+	return nil
+}
+
+func (s *APIServer) handleAdminTaxRate(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "DELETE" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid id type"})
+	}
+	if err := s.store.DeleteTaxRate(r.Context(), id); err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return helpers.WriteJSON(w, http.StatusOK, map[string]string{"status": "tax rate deleted"})
+}
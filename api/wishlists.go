@@ -0,0 +1,197 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/VincentSamuelPaul/production-api/helpers"
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+	"github.com/gorilla/mux"
+)
+
+// WISHLIST FUNCTIONS
+
+type wishlistRequest struct {
+	Name   string `json:"name"`
+	Public bool   `json:"public"`
+}
+
+func (s *APIServer) handleWishlists(w http.ResponseWriter, r *http.Request) error {
+	userid, err := strconv.Atoi(mux.Vars(r)["userid"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid userid type"})
+	}
+	if r.Method == "GET" {
+		data, err := s.store.GetWishlistsByUserID(r.Context(), userid)
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		return helpers.WriteJSON(w, http.StatusOK, data)
+	}
+	if r.Method == "POST" {
+		var req wishlistRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return err
+		}
+		if strings.TrimSpace(req.Name) == "" {
+			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "name is required"})
+		}
+		data, err := s.store.CreateWishlist(r.Context(), userid, strings.TrimSpace(req.Name), req.Public)
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		return helpers.WriteJSON(w, http.StatusCreated, data)
+	}
+	return nil
+}
+
+func (s *APIServer) handleWishlist(w http.ResponseWriter, r *http.Request) error {
+	userid, wishlistid, err := wishlistVars(r)
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+	}
+	if r.Method == "GET" {
+		data, err := s.store.GetWishlist(r.Context(), userid, wishlistid)
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		return helpers.WriteJSON(w, http.StatusOK, data)
+	}
+	if r.Method == "PUT" || r.Method == "PATCH" {
+		var req wishlistRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return err
+		}
+		if strings.TrimSpace(req.Name) == "" {
+			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "name is required"})
+		}
+		data, err := s.store.UpdateWishlist(r.Context(), userid, wishlistid, strings.TrimSpace(req.Name), req.Public)
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		return helpers.WriteJSON(w, http.StatusOK, data)
+	}
+	if r.Method == "DELETE" {
+		err := s.store.DeleteWishlist(r.Context(), userid, wishlistid)
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		return helpers.WriteJSON(w, http.StatusOK, map[string]string{"status": "wishlist deleted"})
+	}
+	return nil
+}
+
+func (s *APIServer) handleWishlistItems(w http.ResponseWriter, r *http.Request) error {
+	userid, wishlistid, err := wishlistVars(r)
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+	}
+	if r.Method == "POST" {
+		var req struct {
+			ProductID int `json:"product_id"`
+			Quantity  int `json:"quantity"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return err
+		}
+		if req.Quantity == 0 {
+			req.Quantity = 1
+		}
+		if req.Quantity < 0 {
+			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "quantity must be positive"})
+		}
+		err := s.store.AddToWishlist(r.Context(), userid, wishlistid, req.ProductID, req.Quantity)
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		return helpers.WriteJSON(w, http.StatusAccepted, map[string]string{"status": "added to wishlist"})
+	}
+	if r.Method == "DELETE" {
+		productid, err := strconv.Atoi(mux.Vars(r)["productid"])
+		if err != nil {
+			return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid productid type"})
+		}
+		err = s.store.RemoveFromWishlist(r.Context(), userid, wishlistid, productid)
+		if err != nil {
+			return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+		}
+		return helpers.WriteJSON(w, http.StatusAccepted, map[string]string{"status": "item removed from wishlist"})
+	}
+	return nil
+}
+
+func (s *APIServer) handleMoveToCart(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	userid, wishlistid, err := wishlistVars(r)
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: err.Error()})
+	}
+	productid, err := strconv.Atoi(mux.Vars(r)["productid"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid productid type"})
+	}
+	quantity, err := s.store.MoveWishlistItemToCart(r.Context(), userid, wishlistid, productid)
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return helpers.WriteJSON(w, http.StatusAccepted, map[string]any{"status": "moved to cart", "quantity": quantity})
+}
+
+// handleSaveForLater moves a cart item to a wishlist. The body may name the
+// target with wishlist_id; otherwise the "Saved for later" list is used.
+func (s *APIServer) handleSaveForLater(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	userid, err := strconv.Atoi(mux.Vars(r)["userid"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid userid type"})
+	}
+	productid, err := strconv.Atoi(mux.Vars(r)["productid"])
+	if err != nil {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Invalid productid type"})
+	}
+	var req struct {
+		WishlistID int `json:"wishlist_id"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return err
+		}
+	}
+	wishlistID, err := s.store.SaveForLater(r.Context(), userid, productid, req.WishlistID)
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	return helpers.WriteJSON(w, http.StatusAccepted, map[string]any{"status": "saved for later", "wishlist_id": wishlistID})
+}
+
+func (s *APIServer) handleSharedWishlist(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return helpers.WriteJSON(w, http.StatusBadRequest, structTypes.ErrorMSG{Error: "Forbidden"})
+	}
+	data, err := s.store.GetSharedWishlist(r.Context(), mux.Vars(r)["token"])
+	if err != nil {
+		return helpers.WriteJSON(w, storeErrorStatus(err), structTypes.ErrorMSG{Error: err.Error()})
+	}
+	// the owner isn't part of the public view
+	data.UserID = 0
+	return helpers.WriteJSON(w, http.StatusOK, data)
+}
+
+func wishlistVars(r *http.Request) (int, int, error) {
+	userid, err := strconv.Atoi(mux.Vars(r)["userid"])
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid userid type")
+	}
+	wishlistid, err := strconv.Atoi(mux.Vars(r)["wishlistid"])
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid wishlistid type")
+	}
+	return userid, wishlistid, nil
+}
@@ -0,0 +1,55 @@
+package checkout
+
+import (
+	"github.com/VincentSamuelPaul/production-api/currency"
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+// Config holds the store wide checkout settings. Values come from:
+//
+//	STORE_CURRENCY             ISO 4217 code prices are kept in, default USD
+//
+// Tax and shipping estimates come from the tax engine and shipping methods
+// orders are charged with, through Storage.EstimateCheckout.
+type Config struct {
+	Currency structTypes.Currency
+}
+
+func ConfigFromEnv() Config {
+	return Config{Currency: currency.BaseFromEnv()}
+}
+
+// Summarize totals the cart lines with the shipping and tax checkout would
+// charge. Tax is only added to the total when prices don't include it.
+func Summarize(items []structTypes.CartProduct, c structTypes.Currency, promo *structTypes.AppliedPromotion, estimate structTypes.CheckoutEstimate) structTypes.CartSummary {
+	summary := structTypes.CartSummary{
+		Items:            items,
+		Promotion:        promo,
+		PricesIncludeTax: estimate.TaxInclusive,
+		EstimateWarning:  estimate.Warning,
+		Currency:         c,
+	}
+	for _, item := range items {
+		summary.ItemCount += item.Quantity
+		summary.Subtotal = summary.Subtotal.Add(item.TotalPrice)
+	}
+	if promo != nil {
+		summary.Discount = promo.Discount.Min(summary.Subtotal)
+	}
+
+	taxable := summary.Subtotal.Sub(summary.Discount)
+	if taxable.Sign() < 0 {
+		taxable = structTypes.NewMoney(0, c)
+	}
+	if summary.ItemCount > 0 {
+		summary.EstimatedTax = estimate.Tax
+		summary.ShippingEstimate = estimate.Shipping
+		summary.ShippingMethod = estimate.ShippingMethod
+	}
+
+	summary.GrandTotal = taxable.Add(summary.ShippingEstimate)
+	if !estimate.TaxInclusive {
+		summary.GrandTotal = summary.GrandTotal.Add(summary.EstimatedTax)
+	}
+	return summary
+}
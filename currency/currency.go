@@ -0,0 +1,178 @@
+package currency
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+// BaseFromEnv reads STORE_CURRENCY, the currency product prices, shipping
+// rates and promotions are kept in (default USD).
+func BaseFromEnv() structTypes.Currency {
+	v := os.Getenv("STORE_CURRENCY")
+	if v == "" {
+		return structTypes.DefaultCurrency
+	}
+	c, err := structTypes.ParseCurrency(v)
+	if err != nil {
+		log.Printf("STORE_CURRENCY: %v, using %s", err, structTypes.DefaultCurrency)
+		return structTypes.DefaultCurrency
+	}
+	return c
+}
+
+// Source supplies exchange rates and price lists. Storage implements it.
+type Source interface {
+	GetExchangeRate(ctx context.Context, c structTypes.Currency) (structTypes.ExchangeRate, error)
+	GetPriceList(ctx context.Context, c structTypes.Currency) (map[int]structTypes.Money, error)
+}
+
+// Converter prices things in Currency. Product prices come from the price
+// list when it has one and are converted from the base price otherwise;
+// every other amount (shipping, promotions) is converted at Rate.
+type Converter struct {
+	Base     structTypes.Currency
+	Currency structTypes.Currency
+	Rate     structTypes.Rate
+	Prices   map[int]structTypes.Money
+}
+
+// Identity leaves amounts in the base currency.
+func Identity(base structTypes.Currency) Converter {
+	return Converter{Base: base, Currency: base, Rate: structTypes.One}
+}
+
+// Load builds the converter for c. An empty c means the base currency; any
+// other currency needs an exchange rate.
+func Load(ctx context.Context, src Source, base, c structTypes.Currency) (Converter, error) {
+	if c == "" || c == base {
+		return Identity(base), nil
+	}
+	rate, err := src.GetExchangeRate(ctx, c)
+	if errors.Is(err, structTypes.ErrExchangeRateNotFound) {
+		return Converter{}, fmt.Errorf("%w: %s", structTypes.ErrCurrencyNotSupported, c)
+	}
+	if err != nil {
+		return Converter{}, err
+	}
+	prices, err := src.GetPriceList(ctx, c)
+	if err != nil {
+		return Converter{}, err
+	}
+	return Converter{Base: base, Currency: c, Rate: rate.Rate, Prices: prices}, nil
+}
+
+func (c Converter) identity() bool {
+	return c.Currency == c.Base
+}
+
+// Amount converts a base currency amount.
+func (c Converter) Amount(m structTypes.Money) structTypes.Money {
+	m = m.Round(c.Base)
+	if c.identity() {
+		return m
+	}
+	return m.Convert(c.Rate, c.Currency)
+}
+
+// Price is the product's unit price in the currency.
+func (c Converter) Price(productID int, base structTypes.Money) structTypes.Money {
+	if price, ok := c.Prices[productID]; ok {
+		return price.Round(c.Currency)
+	}
+	return c.Amount(base)
+}
+
+// Product returns p priced in the currency.
+func (c Converter) Product(p structTypes.Product) structTypes.Product {
+	p.Price = c.Price(p.ID, p.Price)
+	p.Currency = c.Currency
+	return p
+}
+
+// CartItems returns copies of the cart lines priced in the currency.
+func (c Converter) CartItems(items []structTypes.CartProduct) []structTypes.CartProduct {
+	out := make([]structTypes.CartProduct, len(items))
+	for i, item := range items {
+		item.Price = c.Price(item.ProductID, item.Price)
+		item.CurrentPrice = c.Price(item.ProductID, item.CurrentPrice)
+		item.TotalPrice = item.Price.Mul(item.Quantity)
+		out[i] = item
+	}
+	return out
+}
+
+// Validate checks an exchange rate before it is stored. The base currency
+// always has a rate of one and isn't stored.
+func Validate(rate structTypes.ExchangeRate, base structTypes.Currency) error {
+	if rate.Currency == base {
+		return fmt.Errorf("%s is the base currency", base)
+	}
+	if rate.Rate <= 0 {
+		return fmt.Errorf("rate for %s must be positive", rate.Currency)
+	}
+	return nil
+}
+
+// ParseRates reads exchange rates from CSV lines of "currency,rate", such
+// as "EUR,0.9215". Blank lines, lines starting with # and a "currency,rate"
+// header are skipped.
+func ParseRates(r io.Reader, base structTypes.Currency) ([]structTypes.ExchangeRate, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	var rates []structTypes.ExchangeRate
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return rates, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(record[0], "currency") {
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		c, err := structTypes.ParseCurrency(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rate, err := structTypes.ParseRate(record[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		er := structTypes.ExchangeRate{Currency: c, Rate: rate}
+		if err := Validate(er, base); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rates = append(rates, er)
+	}
+}
+
+// RatesFromFile reads the rate file named by EXCHANGE_RATES_FILE, in the
+// format ParseRates takes. It returns no rates when the variable is unset.
+func RatesFromFile(base structTypes.Currency) ([]structTypes.ExchangeRate, error) {
+	path := os.Getenv("EXCHANGE_RATES_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rates, err := ParseRates(f, base)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rates, nil
+}
@@ -0,0 +1,168 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+func (s *PostgresStore) initAbandonedCarts() error {
+	query := `alter table carts add column if not exists updated_at TIMESTAMP NOT NULL DEFAULT now();`
+	_, err := s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	// one row per cart per period of inactivity; cart_updated_at ties the
+	// event to the cart state it was detected on
+	query = `create table if not exists cart_abandonments (
+		id SERIAL PRIMARY KEY,
+		cart_id INT REFERENCES carts(id) ON DELETE CASCADE,
+		user_id INT REFERENCES users(id),
+		item_count INT NOT NULL,
+		cart_value NUMERIC(13,3) NOT NULL,
+		cart_updated_at TIMESTAMP NOT NULL,
+		detected_at TIMESTAMP NOT NULL DEFAULT now(),
+		reminded_at TIMESTAMP,
+		UNIQUE (cart_id, cart_updated_at)
+		);`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// touchCart records activity on the cart so it is not reported as abandoned.
+func touchCart(ctx context.Context, db dbtx, cartID int) error {
+	_, err := execContext(ctx, db, `UPDATE carts SET updated_at = now() WHERE id = $1`, cartID)
+	return err
+}
+
+// RecordAbandonedCarts stores an abandonment event for every non-empty cart
+// untouched since cutoff that doesn't have one for its current state yet.
+func (s *PostgresStore) RecordAbandonedCarts(ctx context.Context, cutoff time.Time) ([]structTypes.CartAbandonment, error) {
+	ctx, span := startMethodSpan(ctx, "RecordAbandonedCarts")
+	defer span.End()
+	query := `
+		INSERT INTO cart_abandonments (cart_id, user_id, item_count, cart_value, cart_updated_at)
+		SELECT c.id, c.user_id, SUM(ci.quantity), SUM(ci.quantity * ci.price_at_time), c.updated_at
+		FROM carts c
+		JOIN cart_items ci ON ci.cart_id = c.id
+		WHERE c.updated_at < $1
+		GROUP BY c.id
+		ON CONFLICT (cart_id, cart_updated_at) DO NOTHING
+		RETURNING id, cart_id, user_id, item_count, cart_value, cart_updated_at, detected_at;
+	`
+	rows, err := queryContext(ctx, s.DB, query, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []structTypes.CartAbandonment
+	for rows.Next() {
+		var event structTypes.CartAbandonment
+		if err := rows.Scan(
+			&event.ID,
+			&event.CartID,
+			&event.UserID,
+			&event.ItemCount,
+			&event.CartValue,
+			&event.LastActivity,
+			&event.DetectedAt,
+		); err != nil {
+			return nil, err
+		}
+		inCurrency(s.base(), &event.CartValue)
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// GetPendingCartReminders lists abandonment events of signed up users that
+// haven't been reminded yet, skipping carts that changed since.
+func (s *PostgresStore) GetPendingCartReminders(ctx context.Context, limit int) ([]structTypes.CartReminder, error) {
+	ctx, span := startMethodSpan(ctx, "GetPendingCartReminders")
+	defer span.End()
+	query := `
+		SELECT a.id, a.cart_id, u.id, u.username, u.email, a.item_count, a.cart_value, a.cart_updated_at
+		FROM cart_abandonments a
+		JOIN users u ON u.id = a.user_id
+		JOIN carts c ON c.id = a.cart_id AND c.updated_at = a.cart_updated_at
+		WHERE a.reminded_at IS NULL
+		ORDER BY a.detected_at
+		LIMIT $1;
+	`
+	rows, err := queryContext(ctx, s.DB, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var reminders []structTypes.CartReminder
+	for rows.Next() {
+		var reminder structTypes.CartReminder
+		if err := rows.Scan(
+			&reminder.AbandonmentID,
+			&reminder.CartID,
+			&reminder.UserID,
+			&reminder.Username,
+			&reminder.Email,
+			&reminder.ItemCount,
+			&reminder.CartValue,
+			&reminder.LastActivity,
+		); err != nil {
+			return nil, err
+		}
+		inCurrency(s.base(), &reminder.CartValue)
+		reminders = append(reminders, reminder)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return reminders, nil
+}
+
+func (s *PostgresStore) MarkCartReminderSent(ctx context.Context, abandonmentID int) error {
+	ctx, span := startMethodSpan(ctx, "MarkCartReminderSent")
+	defer span.End()
+	_, err := execContext(ctx, s.DB, `UPDATE cart_abandonments SET reminded_at = now() WHERE id = $1`, abandonmentID)
+	return err
+}
+
+// GetAbandonmentReport summarises abandonment since the given time. The rate
+// is abandoned carts over carts that had any activity in the period.
+func (s *PostgresStore) GetAbandonmentReport(ctx context.Context, since time.Time) (structTypes.AbandonmentReport, error) {
+	ctx, span := startMethodSpan(ctx, "GetAbandonmentReport")
+	defer span.End()
+	report := structTypes.AbandonmentReport{Since: since}
+	query := `
+		SELECT
+			(SELECT COUNT(DISTINCT cart_id) FROM cart_abandonments WHERE detected_at >= $1),
+			(SELECT COALESCE(SUM(cart_value), 0) FROM cart_abandonments WHERE detected_at >= $1),
+			(SELECT COUNT(*) FROM cart_abandonments WHERE detected_at >= $1 AND reminded_at IS NOT NULL),
+			(SELECT COUNT(*) FROM cart_abandonments a WHERE a.detected_at >= $1
+				AND EXISTS (SELECT 1 FROM orders o WHERE o.user_id = a.user_id AND o.created_at > a.detected_at)),
+			(SELECT COUNT(*) FROM carts c WHERE c.updated_at >= $1
+				OR EXISTS (SELECT 1 FROM cart_abandonments a WHERE a.cart_id = c.id AND a.detected_at >= $1));
+	`
+	var active int
+	err := queryRowContext(ctx, s.DB, query, since).Scan(
+		&report.AbandonedCarts,
+		&report.AbandonedValue,
+		&report.RemindersSent,
+		&report.Recovered,
+		&active,
+	)
+	if err != nil {
+		return report, err
+	}
+	inCurrency(s.base(), &report.AbandonedValue)
+	report.ActiveCarts = active
+	if active > 0 {
+		report.AbandonmentRate = float64(report.AbandonedCarts) / float64(active)
+	}
+	return report, nil
+}
@@ -0,0 +1,239 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+func (s *PostgresStore) initAddresses() error {
+	query := `create table if not exists addresses (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id),
+		label TEXT NOT NULL DEFAULT '',
+		name TEXT NOT NULL,
+		line1 TEXT NOT NULL,
+		line2 TEXT NOT NULL DEFAULT '',
+		city TEXT NOT NULL,
+		region TEXT NOT NULL DEFAULT '',
+		postal_code TEXT NOT NULL DEFAULT '',
+		country CHAR(2) NOT NULL,
+		phone TEXT NOT NULL DEFAULT '',
+		default_shipping BOOLEAN NOT NULL DEFAULT false,
+		default_billing BOOLEAN NOT NULL DEFAULT false,
+		created_at TIMESTAMP DEFAULT now()
+		);`
+	_, err := s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	query = `create unique index if not exists addresses_default_shipping_idx
+		on addresses (user_id) where default_shipping;`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	query = `create unique index if not exists addresses_default_billing_idx
+		on addresses (user_id) where default_billing;`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	// orders keep a copy of the addresses they were placed with
+	query = `alter table orders
+		add column if not exists shipping_address JSONB,
+		add column if not exists billing_address JSONB;`
+	_, err = s.DB.Exec(query)
+	return err
+}
+
+// ADDRESS FUNCTIONS
+
+const addressColumns = `id, label, name, line1, line2, city, region, postal_code, country, phone,
+		default_shipping, default_billing, created_at`
+
+func scanAddress(row interface{ Scan(...any) error }, a *structTypes.Address) error {
+	return row.Scan(&a.ID, &a.Label, &a.Name, &a.Line1, &a.Line2, &a.City, &a.Region, &a.PostalCode,
+		&a.Country, &a.Phone, &a.DefaultShipping, &a.DefaultBilling, &a.CreatedAt)
+}
+
+func (s *PostgresStore) GetAddresses(ctx context.Context, userID int) ([]structTypes.Address, error) {
+	ctx, span := startMethodSpan(ctx, "GetAddresses")
+	defer span.End()
+
+	if err := userExists(ctx, s.DB, userID); err != nil {
+		return nil, err
+	}
+	query := `SELECT ` + addressColumns + ` FROM addresses WHERE user_id = $1 ORDER BY id`
+	rows, err := queryContext(ctx, s.DB, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []structTypes.Address{}
+	for rows.Next() {
+		var a structTypes.Address
+		if err := scanAddress(rows, &a); err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+func getAddress(ctx context.Context, db dbtx, userID, addressID int) (structTypes.Address, error) {
+	var a structTypes.Address
+	query := `SELECT ` + addressColumns + ` FROM addresses WHERE user_id = $1 AND id = $2`
+	err := scanAddress(queryRowContext(ctx, db, query, userID, addressID), &a)
+	if errors.Is(err, sql.ErrNoRows) {
+		return a, fmt.Errorf("%w: id %d", structTypes.ErrAddressNotFound, addressID)
+	}
+	return a, err
+}
+
+func (s *PostgresStore) GetAddress(ctx context.Context, userID, addressID int) (structTypes.Address, error) {
+	ctx, span := startMethodSpan(ctx, "GetAddress")
+	defer span.End()
+	return getAddress(ctx, s.DB, userID, addressID)
+}
+
+// claimDefaults clears the default flags of the user's other addresses for
+// every flag the address is taking over.
+func claimDefaults(ctx context.Context, tx dbtx, userID, addressID int, a structTypes.Address) error {
+	if a.DefaultShipping {
+		query := `UPDATE addresses SET default_shipping = false WHERE user_id = $1 AND id <> $2 AND default_shipping`
+		if _, err := execContext(ctx, tx, query, userID, addressID); err != nil {
+			return err
+		}
+	}
+	if a.DefaultBilling {
+		query := `UPDATE addresses SET default_billing = false WHERE user_id = $1 AND id <> $2 AND default_billing`
+		if _, err := execContext(ctx, tx, query, userID, addressID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateAddress adds an address to the user's book. The first address
+// becomes the default for both shipping and billing.
+func (s *PostgresStore) CreateAddress(ctx context.Context, userID int, a structTypes.Address) (structTypes.Address, error) {
+	ctx, span := startMethodSpan(ctx, "CreateAddress")
+	defer span.End()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return a, err
+	}
+	defer tx.Rollback()
+
+	if err := userExists(ctx, tx, userID); err != nil {
+		return a, err
+	}
+	var count int
+	if err := queryRowContext(ctx, tx, `SELECT COUNT(*) FROM addresses WHERE user_id = $1`, userID).Scan(&count); err != nil {
+		return a, err
+	}
+	if count == 0 {
+		a.DefaultShipping, a.DefaultBilling = true, true
+	}
+	if err := claimDefaults(ctx, tx, userID, 0, a); err != nil {
+		return a, err
+	}
+
+	query := `INSERT INTO addresses (user_id, label, name, line1, line2, city, region, postal_code, country, phone,
+			default_shipping, default_billing)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING ` + addressColumns
+	err = scanAddress(queryRowContext(ctx, tx, query, userID, a.Label, a.Name, a.Line1, a.Line2, a.City,
+		a.Region, a.PostalCode, a.Country, a.Phone, a.DefaultShipping, a.DefaultBilling), &a)
+	if err != nil {
+		return a, err
+	}
+	return a, tx.Commit()
+}
+
+// UpdateAddress replaces the address. Orders placed with it keep their copy.
+func (s *PostgresStore) UpdateAddress(ctx context.Context, userID, addressID int, a structTypes.Address) (structTypes.Address, error) {
+	ctx, span := startMethodSpan(ctx, "UpdateAddress")
+	defer span.End()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return a, err
+	}
+	defer tx.Rollback()
+
+	if err := claimDefaults(ctx, tx, userID, addressID, a); err != nil {
+		return a, err
+	}
+	query := `UPDATE addresses
+		SET label = $3, name = $4, line1 = $5, line2 = $6, city = $7, region = $8, postal_code = $9,
+			country = $10, phone = $11, default_shipping = $12, default_billing = $13
+		WHERE user_id = $1 AND id = $2
+		RETURNING ` + addressColumns
+	err = scanAddress(queryRowContext(ctx, tx, query, userID, addressID, a.Label, a.Name, a.Line1, a.Line2,
+		a.City, a.Region, a.PostalCode, a.Country, a.Phone, a.DefaultShipping, a.DefaultBilling), &a)
+	if errors.Is(err, sql.ErrNoRows) {
+		return a, fmt.Errorf("%w: id %d", structTypes.ErrAddressNotFound, addressID)
+	}
+	if err != nil {
+		return a, err
+	}
+	return a, tx.Commit()
+}
+
+func (s *PostgresStore) DeleteAddress(ctx context.Context, userID, addressID int) error {
+	ctx, span := startMethodSpan(ctx, "DeleteAddress")
+	defer span.End()
+	res, err := execContext(ctx, s.DB, `DELETE FROM addresses WHERE user_id = $1 AND id = $2`, userID, addressID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%w: id %d", structTypes.ErrAddressNotFound, addressID)
+	}
+	return nil
+}
+
+// orderAddress picks the address an order is placed with: the given one,
+// or else the user's default for the flag column. It returns nil when the
+// user has no such default.
+func orderAddress(ctx context.Context, tx dbtx, userID, addressID int, defaultColumn string) (*structTypes.Address, error) {
+	if addressID != 0 {
+		a, err := getAddress(ctx, tx, userID, addressID)
+		if err != nil {
+			return nil, err
+		}
+		return &a, nil
+	}
+	var a structTypes.Address
+	query := `SELECT ` + addressColumns + ` FROM addresses WHERE user_id = $1 AND ` + defaultColumn
+	err := scanAddress(queryRowContext(ctx, tx, query, userID), &a)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// addressSnapshot is the copy of an address stored on an order.
+func addressSnapshot(a *structTypes.Address) ([]byte, error) {
+	if a == nil {
+		return nil, nil
+	}
+	snapshot := *a
+	snapshot.DefaultShipping, snapshot.DefaultBilling = false, false
+	snapshot.CreatedAt = time.Time{}
+	return json.Marshal(snapshot)
+}
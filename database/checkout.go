@@ -0,0 +1,132 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/VincentSamuelPaul/production-api/currency"
+	"github.com/VincentSamuelPaul/production-api/tax"
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+// checkoutLine is an order line as checkout prices it. Amount is the line
+// total in the order currency.
+type checkoutLine struct {
+	ProductID int
+	TaxClass  string
+	Amount    structTypes.Money
+}
+
+type checkoutCosts struct {
+	Method   string
+	Shipping structTypes.Money
+	Discount structTypes.Money
+	Tax      tax.Result
+}
+
+// priceCheckout works out the discount, shipping and tax of an order. Both
+// CreateOrder and cart estimates go through it so they charge alike. Line
+// amounts are in conv's currency; the promotion, baseSubtotal and shipping
+// rates are in the base currency.
+func (s *PostgresStore) priceCheckout(ctx context.Context, db dbtx, conv currency.Converter, address *structTypes.Address,
+	method string, lines []checkoutLine, weight int, baseSubtotal structTypes.Money, applied structTypes.AppliedPromotion) (checkoutCosts, error) {
+	var costs checkoutCosts
+	var err error
+	costs.Method, costs.Shipping, err = orderShipping(ctx, db, method, address.Country, weight, s.base(), baseSubtotal.Sub(applied.Discount))
+	if err != nil {
+		return costs, err
+	}
+	if applied.FreeShipping {
+		costs.Shipping = structTypes.Money{}
+	}
+	costs.Shipping = conv.Amount(costs.Shipping)
+
+	var subtotal structTypes.Money
+	amounts := make([]structTypes.Money, len(lines))
+	for i, line := range lines {
+		amounts[i] = line.Amount
+		subtotal = subtotal.Add(line.Amount)
+	}
+	costs.Discount = conv.Amount(applied.Discount).Min(subtotal)
+
+	taxReq := tax.Request{
+		Country:    address.Country,
+		Region:     address.Region,
+		PostalCode: address.PostalCode,
+		Shipping:   costs.Shipping,
+		Currency:   conv.Currency,
+	}
+	for i, amount := range tax.Allocate(amounts, costs.Discount) {
+		taxReq.Lines = append(taxReq.Lines, tax.Line{ProductID: lines[i].ProductID, TaxClass: lines[i].TaxClass, Amount: amount})
+	}
+	costs.Tax, err = s.calculateTax(ctx, taxReq)
+	return costs, err
+}
+
+// EstimateCheckout prices shipping and tax for cart lines the way
+// CreateOrder would charge them, without reserving anything. Items and the
+// promotion are in the base currency; the estimate is in options.Currency.
+// Carts without a shipping address, or that nothing ships, get an estimate
+// without shipping and tax that says why.
+func (s *PostgresStore) EstimateCheckout(ctx context.Context, userID int, items []structTypes.CartProduct,
+	promo *structTypes.AppliedPromotion, options structTypes.OrderOptions) (structTypes.CheckoutEstimate, error) {
+	ctx, span := startMethodSpan(ctx, "EstimateCheckout")
+	defer span.End()
+	estimate := structTypes.CheckoutEstimate{TaxInclusive: s.taxInclusive}
+	if len(items) == 0 {
+		return estimate, nil
+	}
+
+	conv, err := currency.Load(ctx, s, s.base(), options.Currency)
+	if err != nil {
+		return estimate, err
+	}
+	var address *structTypes.Address
+	if userID != 0 {
+		if address, err = orderAddress(ctx, s.DB, userID, options.ShippingAddressID, "default_shipping"); err != nil {
+			return estimate, err
+		}
+	}
+	if address == nil {
+		estimate.Warning = "tax and shipping are estimated once a shipping address is set"
+		return estimate, nil
+	}
+
+	var baseSubtotal structTypes.Money
+	var weight int
+	lines := make([]checkoutLine, 0, len(items))
+	for _, item := range items {
+		line := checkoutLine{ProductID: item.ProductID, Amount: conv.Price(item.ProductID, item.Price).Mul(item.Quantity)}
+		var grams int
+		query := `SELECT weight_grams, tax_class FROM products WHERE id = $1`
+		err := queryRowContext(ctx, s.DB, query, item.ProductID).Scan(&grams, &line.TaxClass)
+		if errors.Is(err, sql.ErrNoRows) {
+			return estimate, fmt.Errorf("%w: id %d", structTypes.ErrProductNotFound, item.ProductID)
+		}
+		if err != nil {
+			return estimate, err
+		}
+		baseSubtotal = baseSubtotal.Add(item.TotalPrice)
+		weight += grams * item.Quantity
+		lines = append(lines, line)
+	}
+
+	var applied structTypes.AppliedPromotion
+	if promo != nil {
+		applied = *promo
+	}
+	costs, err := s.priceCheckout(ctx, s.DB, conv, address, options.ShippingMethod, lines, weight, baseSubtotal, applied)
+	if errors.Is(err, structTypes.ErrShippingUnavailable) || errors.Is(err, structTypes.ErrShippingMethodNotFound) {
+		estimate.Warning = err.Error()
+		return estimate, nil
+	}
+	if err != nil {
+		return estimate, err
+	}
+	estimate.ShippingMethod = costs.Method
+	estimate.Shipping = costs.Shipping
+	estimate.Tax = costs.Tax.Total
+	return estimate, nil
+}
@@ -0,0 +1,231 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+func (s *PostgresStore) initCurrency() error {
+	query := `create table if not exists exchange_rates (
+		currency CHAR(3) PRIMARY KEY,
+		rate NUMERIC(18,8) NOT NULL CHECK (rate > 0),
+		updated_at TIMESTAMP NOT NULL DEFAULT now()
+		);`
+	_, err := s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	query = `create table if not exists product_prices (
+		product_id INT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+		currency CHAR(3) NOT NULL,
+		price NUMERIC(13,3) NOT NULL CHECK (price >= 0),
+		updated_at TIMESTAMP NOT NULL DEFAULT now(),
+		PRIMARY KEY (product_id, currency)
+		);`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	// the rate the order was converted at, kept so later rate changes don't
+	// touch placed orders
+	query = `alter table orders add column if not exists exchange_rate NUMERIC(18,8) NOT NULL DEFAULT 1;`
+	_, err = s.DB.Exec(query)
+	return err
+}
+
+// widenMoneyColumns moves amounts created as NUMERIC(10,2) to NUMERIC(13,3),
+// so currencies with three decimals are stored without rounding.
+func (s *PostgresStore) widenMoneyColumns() error {
+	query := `DO $$
+		DECLARE col record;
+		BEGIN
+			FOR col IN SELECT table_name, column_name FROM information_schema.columns
+				WHERE table_schema = current_schema() AND data_type = 'numeric'
+					AND numeric_precision = 10 AND numeric_scale = 2
+			LOOP
+				EXECUTE format('ALTER TABLE %I ALTER COLUMN %I TYPE NUMERIC(13,3)', col.table_name, col.column_name);
+			END LOOP;
+		END $$;`
+	_, err := s.DB.Exec(query)
+	return err
+}
+
+// UseBaseCurrency sets the currency product prices are stored in. Orders
+// placed in another currency are converted from it.
+func (s *PostgresStore) UseBaseCurrency(c structTypes.Currency) {
+	s.baseCurrency = c
+}
+
+func (s *PostgresStore) base() structTypes.Currency {
+	if s.baseCurrency == "" {
+		return structTypes.DefaultCurrency
+	}
+	return s.baseCurrency
+}
+
+// inCurrency gives amounts read from NUMERIC columns the currency they are
+// kept in.
+func inCurrency(c structTypes.Currency, amounts ...*structTypes.Money) {
+	for _, m := range amounts {
+		*m = m.Round(c)
+	}
+}
+
+// CURRENCY FUNCTIONS
+
+func (s *PostgresStore) GetExchangeRates(ctx context.Context) ([]structTypes.ExchangeRate, error) {
+	ctx, span := startMethodSpan(ctx, "GetExchangeRates")
+	defer span.End()
+
+	rows, err := queryContext(ctx, s.DB, `SELECT currency, rate, updated_at FROM exchange_rates ORDER BY currency`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []structTypes.ExchangeRate{}
+	for rows.Next() {
+		var rate structTypes.ExchangeRate
+		if err := rows.Scan(&rate.Currency, &rate.Rate, &rate.UpdatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, rate)
+	}
+	return list, rows.Err()
+}
+
+func (s *PostgresStore) GetExchangeRate(ctx context.Context, c structTypes.Currency) (structTypes.ExchangeRate, error) {
+	ctx, span := startMethodSpan(ctx, "GetExchangeRate")
+	defer span.End()
+
+	rate := structTypes.ExchangeRate{Currency: c}
+	query := `SELECT rate, updated_at FROM exchange_rates WHERE currency = $1`
+	err := queryRowContext(ctx, s.DB, query, c).Scan(&rate.Rate, &rate.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return rate, fmt.Errorf("%w: %s", structTypes.ErrExchangeRateNotFound, c)
+	}
+	return rate, err
+}
+
+// PutExchangeRates creates or replaces the rates in one transaction, so an
+// import is applied entirely or not at all.
+func (s *PostgresStore) PutExchangeRates(ctx context.Context, rates []structTypes.ExchangeRate) error {
+	ctx, span := startMethodSpan(ctx, "PutExchangeRates")
+	defer span.End()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO exchange_rates (currency, rate) VALUES ($1, $2)
+		ON CONFLICT (currency) DO UPDATE SET rate = EXCLUDED.rate, updated_at = now()`
+	for _, rate := range rates {
+		if _, err := execContext(ctx, tx, query, rate.Currency, rate.Rate); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) DeleteExchangeRate(ctx context.Context, c structTypes.Currency) error {
+	ctx, span := startMethodSpan(ctx, "DeleteExchangeRate")
+	defer span.End()
+	res, err := execContext(ctx, s.DB, `DELETE FROM exchange_rates WHERE currency = $1`, c)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%w: %s", structTypes.ErrExchangeRateNotFound, c)
+	}
+	return nil
+}
+
+// GetPriceList returns the fixed prices in a currency by product ID.
+func (s *PostgresStore) GetPriceList(ctx context.Context, c structTypes.Currency) (map[int]structTypes.Money, error) {
+	ctx, span := startMethodSpan(ctx, "GetPriceList")
+	defer span.End()
+
+	rows, err := queryContext(ctx, s.DB, `SELECT product_id, price FROM product_prices WHERE currency = $1`, c)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prices := map[int]structTypes.Money{}
+	for rows.Next() {
+		var id int
+		var price structTypes.Money
+		if err := rows.Scan(&id, &price); err != nil {
+			return nil, err
+		}
+		prices[id] = price.Round(c)
+	}
+	return prices, rows.Err()
+}
+
+func (s *PostgresStore) GetProductPrices(ctx context.Context, productID int) ([]structTypes.ProductPrice, error) {
+	ctx, span := startMethodSpan(ctx, "GetProductPrices")
+	defer span.End()
+
+	query := `SELECT product_id, currency, price, updated_at FROM product_prices
+		WHERE product_id = $1 ORDER BY currency`
+	rows, err := queryContext(ctx, s.DB, query, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []structTypes.ProductPrice{}
+	for rows.Next() {
+		var price structTypes.ProductPrice
+		if err := rows.Scan(&price.ProductID, &price.Currency, &price.Price, &price.UpdatedAt); err != nil {
+			return nil, err
+		}
+		inCurrency(price.Currency, &price.Price)
+		list = append(list, price)
+	}
+	return list, rows.Err()
+}
+
+// PutProductPrice sets the product's price in a currency, replacing any
+// price it had there.
+func (s *PostgresStore) PutProductPrice(ctx context.Context, price structTypes.ProductPrice) (structTypes.ProductPrice, error) {
+	ctx, span := startMethodSpan(ctx, "PutProductPrice")
+	defer span.End()
+
+	var exists bool
+	if err := queryRowContext(ctx, s.DB, `SELECT EXISTS (SELECT 1 FROM products WHERE id = $1)`, price.ProductID).Scan(&exists); err != nil {
+		return price, err
+	}
+	if !exists {
+		return price, fmt.Errorf("%w: id %d", structTypes.ErrProductNotFound, price.ProductID)
+	}
+	query := `INSERT INTO product_prices (product_id, currency, price) VALUES ($1, $2, $3)
+		ON CONFLICT (product_id, currency) DO UPDATE SET price = EXCLUDED.price, updated_at = now()
+		RETURNING updated_at`
+	err := queryRowContext(ctx, s.DB, query, price.ProductID, price.Currency, price.Price).Scan(&price.UpdatedAt)
+	return price, err
+}
+
+func (s *PostgresStore) DeleteProductPrice(ctx context.Context, productID int, c structTypes.Currency) error {
+	ctx, span := startMethodSpan(ctx, "DeleteProductPrice")
+	defer span.End()
+	res, err := execContext(ctx, s.DB, `DELETE FROM product_prices WHERE product_id = $1 AND currency = $2`, productID, c)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%w: product %d in %s", structTypes.ErrProductPriceNotFound, productID, c)
+	}
+	return nil
+}
@@ -1,15 +1,31 @@
 package database
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"sync/atomic"
 
+	"github.com/VincentSamuelPaul/production-api/currency"
+	"github.com/VincentSamuelPaul/production-api/promotions"
+	"github.com/VincentSamuelPaul/production-api/tax"
 	structTypes "github.com/VincentSamuelPaul/production-api/types"
 	_ "github.com/lib/pq"
 )
 
 type PostgresStore struct {
 	DB *sql.DB
+	// migrated is set once Init has created every table, so readiness
+	// checks can tell a fresh connection from a usable schema.
+	migrated atomic.Bool
+
+	tax          tax.Calculator
+	taxInclusive bool
+	baseCurrency structTypes.Currency
 }
 
 func NewPostgresStore() (*PostgresStore, error) {
@@ -42,7 +58,7 @@ func (s *PostgresStore) Init() error {
 		id SERIAL PRIMARY KEY,
 		name TEXT NOT NULL,
 		description TEXT,
-		price NUMERIC(10,2) NOT NULL,
+		price NUMERIC(13,3) NOT NULL,
 		stock INT NOT NULL,
 		created_at TIMESTAMP DEFAULT now()
 		);`
@@ -50,6 +66,13 @@ func (s *PostgresStore) Init() error {
 	if err != nil {
 		return err
 	}
+	query = `alter table products
+		add column if not exists max_per_order INT CHECK (max_per_order > 0),
+		add column if not exists discontinued BOOLEAN NOT NULL DEFAULT false;`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
 	query = `create table if not exists carts (
 		id SERIAL PRIMARY KEY,
 		user_id INT REFERENCES users(id),
@@ -69,10 +92,34 @@ func (s *PostgresStore) Init() error {
 	if err != nil {
 		return err
 	}
+	// price_at_time snapshots the product price when the item is added
+	query = `alter table cart_items add column if not exists price_at_time NUMERIC(13,3);`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	query = `update cart_items ci set price_at_time = p.price
+		from products p
+		where p.id = ci.product_id and ci.price_at_time is null;`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	// guest carts have no user and are identified by an opaque token
+	query = `alter table carts add column if not exists token TEXT UNIQUE;`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	query = `create unique index if not exists cart_items_cart_product_idx on cart_items (cart_id, product_id);`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
 	query = `create table if not exists orders (
 		id SERIAL PRIMARY KEY,
 		user_id INT REFERENCES users(id),
-		total NUMERIC(10,2) NOT NULL,
+		total NUMERIC(13,3) NOT NULL,
 		status TEXT DEFAULT 'pending',
 		created_at TIMESTAMP DEFAULT now()
 		);`
@@ -85,12 +132,28 @@ func (s *PostgresStore) Init() error {
 		order_id INT REFERENCES orders(id),
 		product_id INT REFERENCES products(id),
 		quantity INT NOT NULL,
-		price NUMERIC(10,2) NOT NULL
+		price NUMERIC(13,3) NOT NULL
 		);`
 	_, err = s.DB.Exec(query)
 	if err != nil {
 		return err
 	}
+	// cancelled orders are kept with who cancelled them and why
+	query = `alter table orders
+		add column if not exists cancelled_at TIMESTAMP,
+		add column if not exists cancel_reason TEXT,
+		add column if not exists cancelled_by TEXT;`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	// every amount on an order is in the order's currency
+	query = `alter table orders
+		add column if not exists currency CHAR(3) NOT NULL DEFAULT 'USD';`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
 	query = `create table if not exists reviews (
 		id SERIAL PRIMARY KEY,
 		user_id INT REFERENCES users(id),
@@ -103,6 +166,52 @@ func (s *PostgresStore) Init() error {
 	if err != nil {
 		return err
 	}
+	query = `create table if not exists rate_limits (
+		key TEXT PRIMARY KEY,
+		tokens DOUBLE PRECISION NOT NULL,
+		allowed BOOLEAN NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+		);`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	if err := s.initAbandonedCarts(); err != nil {
+		return err
+	}
+	if err := s.initWishlists(); err != nil {
+		return err
+	}
+	if err := s.initPromotions(); err != nil {
+		return err
+	}
+	if err := s.initPayments(); err != nil {
+		return err
+	}
+	if err := s.initIdempotency(); err != nil {
+		return err
+	}
+	if err := s.initReturns(); err != nil {
+		return err
+	}
+	if err := s.initAddresses(); err != nil {
+		return err
+	}
+	if err := s.initShipping(); err != nil {
+		return err
+	}
+	if err := s.initTax(); err != nil {
+		return err
+	}
+	if err := s.initCurrency(); err != nil {
+		return err
+	}
+	if err := s.widenMoneyColumns(); err != nil {
+		return err
+	}
+	if err := s.initReviews(); err != nil {
+		return err
+	}
 	// p := structTypes.Product{
 	// 	Name:        "Computer",
 	// 	Description: "macbook air 2020",
@@ -115,268 +224,793 @@ func (s *PostgresStore) Init() error {
 	// if err != nil {
 	// 	return err
 	// }
+	s.migrated.Store(true)
 	return nil
 }
 
+// HEALTH FUNCTIONS
+
+func (s *PostgresStore) Ping(ctx context.Context) error {
+	return s.DB.PingContext(ctx)
+}
+
+func (s *PostgresStore) MigrationsApplied() bool {
+	return s.migrated.Load()
+}
+
 // AUTH FUNCTIONS
 
-func (s *PostgresStore) CreateUser(user *structTypes.UserAccount) error {
+func (s *PostgresStore) CreateUser(ctx context.Context, user *structTypes.UserAccount) error {
+	ctx, span := startMethodSpan(ctx, "CreateUser")
+	defer span.End()
 	query := `INSERT INTO users(username, email, password_hash, created_at) VALUES($1, $2, $3, $4) RETURNING id;`
 	var userId int
-	err := s.DB.QueryRow(query, user.Username, user.Email, user.Password_hash, user.Created_at).Scan(&userId)
+	err := queryRowContext(ctx, s.DB, query, user.Username, user.Email, user.Password_hash, user.Created_at).Scan(&userId)
 	if err != nil {
 		return err
 	}
 	query2 := `INSERT INTO carts (user_id) VALUES ($1) RETURNING id;`
-	_, err = s.DB.Exec(query2, userId)
+	_, err = execContext(ctx, s.DB, query2, userId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *PostgresStore) GetUserByUsername(ctx context.Context, username string) (structTypes.UserAccount, error) {
+	ctx, span := startMethodSpan(ctx, "GetUserByUsername")
+	defer span.End()
+	var account structTypes.UserAccount
+	query := `SELECT id, username, email, password_hash, created_at FROM users WHERE username = $1 OR email = $1`
+	err := queryRowContext(ctx, s.DB, query, username).Scan(
+		&account.ID,
+		&account.Username,
+		&account.Email,
+		&account.Password_hash,
+		&account.Created_at,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return account, structTypes.ErrUserNotFound
+	}
+	return account, err
+}
+
+func userExists(ctx context.Context, db dbtx, userID int) error {
+	var exists bool
+	err := queryRowContext(ctx, db, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, userID).Scan(&exists)
 	if err != nil {
 		return err
 	}
+	if !exists {
+		return fmt.Errorf("%w: id %d", structTypes.ErrUserNotFound, userID)
+	}
 	return nil
 }
 
-func (s *PostgresStore) GetData() {
-	query := "select * from users;"
-	data, err := s.DB.Query(query)
+func (s *PostgresStore) GetData(ctx context.Context) ([]structTypes.UserAccount, error) {
+	ctx, span := startMethodSpan(ctx, "GetData")
+	defer span.End()
+	query := "select id, username, email, password_hash, created_at from users;"
+	data, err := queryContext(ctx, s.DB, query)
 	if err != nil {
-		return
+		return nil, err
 	}
+	defer data.Close()
+	var accounts []structTypes.UserAccount
 	for data.Next() {
 		var account structTypes.UserAccount
-		data.Scan(
+		if err := data.Scan(
 			&account.ID,
 			&account.Username,
 			&account.Email,
 			&account.Password_hash,
 			&account.Created_at,
-		)
-		fmt.Println(account)
+		); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
 	}
+	return accounts, data.Err()
 }
 
 // PRODUCT FUNCTIONS
 
-func (s *PostgresStore) GetAllProducts() ([]structTypes.Product, error) {
+const productColumns = "p.id, p.name, p.description, COALESCE(p.category, ''), p.price, p.stock, p.max_per_order, p.discontinued, p.weight_grams, p.tax_class, p.created_at, " +
+	"COALESCE(pr.average, 0), COALESCE(pr.review_count, 0), " +
+	"COALESCE(pr.stars_1, 0), COALESCE(pr.stars_2, 0), COALESCE(pr.stars_3, 0), COALESCE(pr.stars_4, 0), COALESCE(pr.stars_5, 0)"
+
+// productFrom joins each product's rating summary.
+const productFrom = " from products p left join product_ratings pr on pr.product_id = p.id"
+
+// productSorts maps the ?sort= keys of the product list to columns.
+var productSorts = map[string]string{
+	"":        "p.id",
+	"id":      "p.id",
+	"price":   "p.price",
+	"newest":  "p.created_at",
+	"rating":  "COALESCE(pr.average, 0)",
+	"reviews": "COALESCE(pr.review_count, 0)",
+}
+
+// scanProduct reads a product priced in the base currency c.
+func scanProduct(row interface{ Scan(...any) error }, product *structTypes.Product, c structTypes.Currency) error {
+	err := row.Scan(
+		&product.ID,
+		&product.Name,
+		&product.Description,
+		&product.Category,
+		&product.Price,
+		&product.Stock,
+		&product.MaxPerOrder,
+		&product.Discontinued,
+		&product.WeightGrams,
+		&product.TaxClass,
+		&product.Created_at,
+		&product.Rating.Average,
+		&product.Rating.Count,
+		&product.Rating.Histogram[0],
+		&product.Rating.Histogram[1],
+		&product.Rating.Histogram[2],
+		&product.Rating.Histogram[3],
+		&product.Rating.Histogram[4],
+	)
+	inCurrency(c, &product.Price)
+	return err
+}
+
+func (s *PostgresStore) GetAllProducts(ctx context.Context, filter structTypes.ProductFilter) ([]structTypes.Product, error) {
+	ctx, span := startMethodSpan(ctx, "GetAllProducts")
+	defer span.End()
 	var Products []structTypes.Product
-	query := "select * from products;"
-	data, err := s.DB.Query(query)
+	sort, err := sortColumn(filter.Sort, productSorts)
+	if err != nil {
+		return nil, err
+	}
+	q := newQuery("select " + productColumns + productFrom)
+	if filter.MinRating > 0 {
+		q.Where("pr.average >= ?", filter.MinRating)
+	}
+	q.OrderBy(sort, filter.Desc)
+	if sort != "p.id" {
+		q.OrderBy("p.id", false)
+	}
+	query, args := q.Build()
+	data, err := queryContext(ctx, s.DB, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer data.Close()
 	for data.Next() {
 		var product structTypes.Product
-		data.Scan(
-			&product.ID,
-			&product.Name,
-			&product.Description,
-			&product.Price,
-			&product.Stock,
-			&product.Created_at,
-		)
+		if err := scanProduct(data, &product, s.base()); err != nil {
+			return nil, err
+		}
 		Products = append(Products, product)
 	}
+	if err := data.Err(); err != nil {
+		return nil, err
+	}
 	return Products, nil
 }
 
-func (s *PostgresStore) GetProductByID(id int) (structTypes.Product, error) {
+func (s *PostgresStore) GetProductByID(ctx context.Context, id int) (structTypes.Product, error) {
+	ctx, span := startMethodSpan(ctx, "GetProductByID")
+	defer span.End()
 	var product structTypes.Product
-	query := fmt.Sprintf("select * from products where id = %d;", id)
-	data, err := s.DB.Query(query)
+	query := "select " + productColumns + productFrom + " where p.id = $1;"
+	err := scanProduct(queryRowContext(ctx, s.DB, query, id), &product, s.base())
+	if errors.Is(err, sql.ErrNoRows) {
+		return product, fmt.Errorf("%w: id %d", structTypes.ErrProductNotFound, id)
+	}
 	if err != nil {
 		return product, err
 	}
-	for data.Next() {
-		data.Scan(
-			&product.ID,
-			&product.Name,
-			&product.Description,
-			&product.Price,
-			&product.Stock,
-			&product.Created_at,
-		)
-	}
 	return product, nil
 }
 
 // CART FUNCTIONS
 
-func (s *PostgresStore) GetCartByID(id int) ([]structTypes.CartProduct, error) {
-	var cartProducts []structTypes.CartProduct
-	query := fmt.Sprintf(`SELECT 
+// CartIDForUser tells a missing user apart from a user without a cart.
+func (s *PostgresStore) CartIDForUser(ctx context.Context, userID int) (int, error) {
+	return cartIDForUser(ctx, s.DB, userID)
+}
+
+func cartIDForUser(ctx context.Context, db dbtx, userID int) (int, error) {
+	var cartID sql.NullInt64
+	query := `SELECT c.id FROM users u LEFT JOIN carts c ON c.user_id = u.id WHERE u.id = $1 ORDER BY c.id LIMIT 1`
+	err := queryRowContext(ctx, db, query, userID).Scan(&cartID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%w: id %d", structTypes.ErrUserNotFound, userID)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !cartID.Valid {
+		return 0, fmt.Errorf("%w: user %d", structTypes.ErrCartNotFound, userID)
+	}
+	return int(cartID.Int64), nil
+}
+
+// CartIDForToken resolves the guest cart a token was issued for.
+func (s *PostgresStore) CartIDForToken(ctx context.Context, token string) (int, error) {
+	ctx, span := startMethodSpan(ctx, "CartIDForToken")
+	defer span.End()
+	var cartID int
+	query := `SELECT id FROM carts WHERE token = $1 AND user_id IS NULL`
+	err := queryRowContext(ctx, s.DB, query, token).Scan(&cartID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, structTypes.ErrCartNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return cartID, nil
+}
+
+// CreateGuestCart opens a cart with no owner, identified by a random token.
+func (s *PostgresStore) CreateGuestCart(ctx context.Context) (int, string, error) {
+	ctx, span := startMethodSpan(ctx, "CreateGuestCart")
+	defer span.End()
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return 0, "", err
+	}
+	token := hex.EncodeToString(buf)
+	var cartID int
+	query := `INSERT INTO carts (token) VALUES ($1) RETURNING id;`
+	if err := queryRowContext(ctx, s.DB, query, token).Scan(&cartID); err != nil {
+		return 0, "", err
+	}
+	return cartID, token, nil
+}
+
+// mergeQuantity is the ON CONFLICT update applied when a product is in both
+// the guest and the user cart.
+var mergeQuantity = map[structTypes.CartMergeStrategy]string{
+	structTypes.MergeSum:       "cart_items.quantity + EXCLUDED.quantity",
+	structTypes.MergeMax:       "GREATEST(cart_items.quantity, EXCLUDED.quantity)",
+	structTypes.MergeKeepUser:  "cart_items.quantity",
+	structTypes.MergeKeepGuest: "EXCLUDED.quantity",
+}
+
+// MergeGuestCart moves every item of the guest cart into the user's cart and
+// deletes the guest cart. Products present in both are combined according
+// to strategy; the user's price snapshot is kept for them.
+func (s *PostgresStore) MergeGuestCart(ctx context.Context, token string, userID int, strategy structTypes.CartMergeStrategy) error {
+	ctx, span := startMethodSpan(ctx, "MergeGuestCart")
+	defer span.End()
+	update, ok := mergeQuantity[strategy]
+	if !ok {
+		return fmt.Errorf("unknown cart merge strategy %q", strategy)
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var guestID int
+	err = queryRowContext(ctx, tx, `SELECT id FROM carts WHERE token = $1 AND user_id IS NULL FOR UPDATE`, token).Scan(&guestID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return structTypes.ErrCartNotFound
+	}
+	if err != nil {
+		return err
+	}
+	userCartID, err := cartIDForUser(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO cart_items (cart_id, product_id, quantity, price_at_time)
+		SELECT $1, product_id, quantity, price_at_time FROM cart_items WHERE cart_id = $2
+		ON CONFLICT (cart_id, product_id)
+		DO UPDATE SET quantity = ` + update + `;
+	`
+	if _, err := execContext(ctx, tx, query, userCartID, guestID); err != nil {
+		return err
+	}
+	// a code applied as a guest carries over unless the user has one
+	query = `
+		INSERT INTO cart_promotions (cart_id, promotion_id)
+		SELECT $1, promotion_id FROM cart_promotions WHERE cart_id = $2
+		ON CONFLICT (cart_id) DO NOTHING;
+	`
+	if _, err := execContext(ctx, tx, query, userCartID, guestID); err != nil {
+		return err
+	}
+	if _, err := execContext(ctx, tx, `DELETE FROM cart_items WHERE cart_id = $1`, guestID); err != nil {
+		return err
+	}
+	if _, err := execContext(ctx, tx, `DELETE FROM carts WHERE id = $1`, guestID); err != nil {
+		return err
+	}
+	if err := touchCart(ctx, tx, userCartID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) GetCartByID(ctx context.Context, cartID int) ([]structTypes.CartProduct, error) {
+	ctx, span := startMethodSpan(ctx, "GetCartByID")
+	defer span.End()
+	cartProducts := []structTypes.CartProduct{}
+	query := `SELECT 
     ci.id AS cart_item_id,
     p.id AS product_id,
     p.name AS product_name,
     p.description,
+    COALESCE(p.category, ''),
     ci.quantity,
+    p.weight_grams,
     ci.price_at_time,
-    (ci.quantity * ci.price_at_time) AS total_price
-	FROM carts c
-	JOIN cart_items ci ON ci.cart_id = c.id
+    (ci.quantity * ci.price_at_time) AS total_price,
+    p.price AS current_price,
+    p.stock,
+    p.discontinued
+	FROM cart_items ci
 	JOIN products p ON p.id = ci.product_id
-	WHERE c.user_id = %d;
-	`, id)
-	data, err := s.DB.Query(query)
+	WHERE ci.cart_id = $1
+	ORDER BY ci.id;
+	`
+	data, err := queryContext(ctx, s.DB, query, cartID)
 	if err != nil {
-		return cartProducts, err
+		return nil, err
 	}
+	defer data.Close()
 	for data.Next() {
 		var cartProduct structTypes.CartProduct
-		data.Scan(
+		var discontinued bool
+		if err := data.Scan(
 			&cartProduct.CartItemID,
 			&cartProduct.ProductID,
 			&cartProduct.ProductName,
 			&cartProduct.ProductDescription,
+			&cartProduct.Category,
 			&cartProduct.Quantity,
+			&cartProduct.WeightGrams,
 			&cartProduct.Price,
 			&cartProduct.TotalPrice,
-		)
+			&cartProduct.CurrentPrice,
+			&cartProduct.AvailableStock,
+			&discontinued,
+		); err != nil {
+			return nil, err
+		}
+		inCurrency(s.base(), &cartProduct.Price, &cartProduct.TotalPrice, &cartProduct.CurrentPrice)
+		cartProduct.PriceChanged = cartProduct.CurrentPrice != cartProduct.Price
+		annotateAvailability(&cartProduct, discontinued)
 		cartProducts = append(cartProducts, cartProduct)
 	}
+	if err := data.Err(); err != nil {
+		return nil, err
+	}
 	return cartProducts, nil
 }
 
-func (s *PostgresStore) AddToCart(userID, productID, quantity int) error {
-	var cartID int
-	err := s.DB.QueryRow(`SELECT id FROM carts WHERE user_id = $1`, userID).Scan(&cartID)
+// annotateAvailability warns about lines that can't be checked out as they
+// are.
+func annotateAvailability(item *structTypes.CartProduct, discontinued bool) {
+	switch {
+	case discontinued:
+		item.Availability = structTypes.AvailabilityDiscontinued
+		item.Warning = "this product is no longer sold"
+	case item.AvailableStock <= 0:
+		item.Availability = structTypes.AvailabilityOutOfStock
+		item.Warning = "this product is out of stock"
+	case item.Quantity > item.AvailableStock:
+		item.Availability = structTypes.AvailabilityReducedStock
+		item.Warning = fmt.Sprintf("only %d left in stock", item.AvailableStock)
+	default:
+		item.Availability = structTypes.AvailabilityInStock
+	}
+}
+
+// putCartItem sets the quantity of a product in the cart to next(current),
+// after checking the product can be sold in that quantity. Quantities over
+// the product's max_per_order are capped. It returns the stored quantity.
+// tx should be a transaction so the checks and the write see the same rows.
+func putCartItem(ctx context.Context, tx dbtx, cartID, productID int, next func(current int) int) (int, error) {
+	var stock int
+	var maxPerOrder sql.NullInt64
+	var discontinued bool
+	query := `SELECT stock, max_per_order, discontinued FROM products WHERE id = $1`
+	err := queryRowContext(ctx, tx, query, productID).Scan(&stock, &maxPerOrder, &discontinued)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%w: id %d", structTypes.ErrProductNotFound, productID)
+	}
 	if err != nil {
-		return fmt.Errorf("cart not found for user %d: %w", userID, err)
+		return 0, err
 	}
-	query := `
-		INSERT INTO cart_items (cart_id, product_id, quantity)
-		VALUES ($1, $2, $3)
+
+	var current int
+	query = `SELECT quantity FROM cart_items WHERE cart_id = $1 AND product_id = $2 FOR UPDATE`
+	err = queryRowContext(ctx, tx, query, cartID, productID).Scan(&current)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	quantity := next(current)
+	if maxPerOrder.Valid && quantity > int(maxPerOrder.Int64) {
+		quantity = int(maxPerOrder.Int64)
+	}
+	switch {
+	case discontinued:
+		return 0, fmt.Errorf("%w: id %d", structTypes.ErrProductDiscontinued, productID)
+	case stock <= 0:
+		return 0, fmt.Errorf("%w: id %d", structTypes.ErrOutOfStock, productID)
+	case quantity > stock:
+		return 0, fmt.Errorf("%w for product_id %d (available: %d, requested: %d)",
+			structTypes.ErrInsufficientStock, productID, stock, quantity)
+	}
+
+	query = `
+		INSERT INTO cart_items (cart_id, product_id, quantity, price_at_time)
+		SELECT $1, p.id, $3, p.price FROM products p WHERE p.id = $2
 		ON CONFLICT (cart_id, product_id)
-		DO UPDATE SET quantity = cart_items.quantity + EXCLUDED.quantity;
+		DO UPDATE SET quantity = EXCLUDED.quantity;
 	`
-	_, err = s.DB.Exec(query, cartID, productID, quantity)
-	return err
+	if _, err := execContext(ctx, tx, query, cartID, productID, quantity); err != nil {
+		return 0, err
+	}
+	if err := touchCart(ctx, tx, cartID); err != nil {
+		return 0, err
+	}
+	return quantity, nil
 }
 
-func (s *PostgresStore) EmptyCart(userID int) error {
-	_, err := s.DB.Exec(`
-        DELETE FROM cart_items
-        WHERE cart_id = (SELECT id FROM carts WHERE user_id = $1)
-    `, userID)
-	return err
+// updateCartItem runs putCartItem in its own transaction.
+func (s *PostgresStore) updateCartItem(ctx context.Context, cartID, productID int, next func(current int) int) (int, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	quantity, err := putCartItem(ctx, tx, cartID, productID, next)
+	if err != nil {
+		return 0, err
+	}
+	return quantity, tx.Commit()
+}
+
+// AddToCart adds quantity to the item, snapshotting the current product
+// price the first time the product is put in the cart.
+func (s *PostgresStore) AddToCart(ctx context.Context, cartID, productID, quantity int) (int, error) {
+	ctx, span := startMethodSpan(ctx, "AddToCart")
+	defer span.End()
+	return s.updateCartItem(ctx, cartID, productID, func(current int) int {
+		return current + quantity
+	})
+}
+
+// SetCartItemQuantity sets an absolute quantity for the product, adding it
+// when missing. A quantity of 0 removes the item.
+func (s *PostgresStore) SetCartItemQuantity(ctx context.Context, cartID, productID, quantity int) (int, error) {
+	ctx, span := startMethodSpan(ctx, "SetCartItemQuantity")
+	defer span.End()
+	if quantity == 0 {
+		return 0, s.DeleteFromCart(ctx, cartID, productID)
+	}
+	return s.updateCartItem(ctx, cartID, productID, func(int) int {
+		return quantity
+	})
+}
+
+func (s *PostgresStore) EmptyCart(ctx context.Context, cartID int) error {
+	ctx, span := startMethodSpan(ctx, "EmptyCart")
+	defer span.End()
+	_, err := execContext(ctx, s.DB, `DELETE FROM cart_items WHERE cart_id = $1`, cartID)
+	if err != nil {
+		return err
+	}
+	return touchCart(ctx, s.DB, cartID)
 }
 
-func (s *PostgresStore) DeleteFromCart(userID, productID int) error {
-	_, err := s.DB.Exec(`
+func (s *PostgresStore) DeleteFromCart(ctx context.Context, cartID, productID int) error {
+	ctx, span := startMethodSpan(ctx, "DeleteFromCart")
+	defer span.End()
+	res, err := execContext(ctx, s.DB, `
         DELETE FROM cart_items
-        WHERE cart_id = (SELECT id FROM carts WHERE user_id = $1)
+        WHERE cart_id = $1
         AND product_id = $2
-    `, userID, productID)
-	return err
+    `, cartID, productID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%w: product %d", structTypes.ErrCartItemNotFound, productID)
+	}
+	return touchCart(ctx, s.DB, cartID)
 }
 
 // ORDER FUNCTIONS
 
-func (s *PostgresStore) CreateOrder(userID int, orders []structTypes.OrderRequest) error {
-	insertQuery := `INSERT INTO orders (user_id, product_id, quantity, price)
-                    VALUES ($1, $2, $3, $4);`
+// CreateOrder reserves stock for every item and writes the order with its
+// items in one transaction, so a failure part way leaves nothing behind.
+// Items are charged the current product price; a promotion applied to the
+// user's cart is redeemed against them and locked into the order. The
+// shipping and billing addresses are copied onto the order; billing falls
+// back to the shipping address. Shipping is priced on the discounted value,
+// and tax is worked out per line for the shipping address.
+func (s *PostgresStore) CreateOrder(ctx context.Context, userID int, orders []structTypes.OrderRequest, options structTypes.OrderOptions) (int, error) {
+	ctx, span := startMethodSpan(ctx, "CreateOrder")
+	defer span.End()
+	if len(orders) == 0 {
+		return 0, fmt.Errorf("order has no items")
+	}
+
+	getStockQuery := `SELECT stock, price, COALESCE(category, ''), weight_grams, tax_class FROM products WHERE id = $1 FOR UPDATE;`
 
 	updateQuery := `UPDATE products
                     SET stock = stock - $1
                     WHERE id = $2 AND stock >= $1;`
 
-	getStockQuery := `SELECT stock FROM products WHERE id = $1;`
+	insertOrderQuery := `INSERT INTO orders (user_id, subtotal, total, shipping_address, billing_address, currency)
+                    VALUES ($1, $2, $2, $3, $4, $5) RETURNING id;`
+
+	insertItemQuery := `INSERT INTO order_items (order_id, product_id, quantity, price)
+                    VALUES ($1, $2, $3, $4) RETURNING id;`
 
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// prices, promotions and shipping rates are kept in the base currency;
+	// the order is charged in its own currency at the rate locked in here
+	conv, err := currency.Load(ctx, s, s.base(), options.Currency)
+	if err != nil {
+		return 0, err
+	}
+	shipping, err := orderAddress(ctx, tx, userID, options.ShippingAddressID, "default_shipping")
+	if err != nil {
+		return 0, err
+	}
+	if shipping == nil {
+		return 0, structTypes.ErrShippingAddressRequired
+	}
+	billing, err := orderAddress(ctx, tx, userID, options.BillingAddressID, "default_billing")
+	if err != nil {
+		return 0, err
+	}
+	if billing == nil {
+		billing = shipping
+	}
+	shipTo, err := addressSnapshot(shipping)
+	if err != nil {
+		return 0, err
+	}
+	billTo, err := addressSnapshot(billing)
+	if err != nil {
+		return 0, err
+	}
+
+	var subtotal, baseSubtotal structTypes.Money
+	var weight int
+	lines := make([]promotions.Line, 0, len(orders))
+	prices := make([]structTypes.Money, 0, len(orders))
+	priced := make([]checkoutLine, 0, len(orders))
 	for _, order := range orders {
-		var stock int
-		err := s.DB.QueryRow(getStockQuery, order.ProductID).Scan(&stock)
+		if order.Quantity <= 0 {
+			return 0, fmt.Errorf("invalid quantity %d for product_id %d", order.Quantity, order.ProductID)
+		}
+		line := promotions.Line{ProductID: order.ProductID, Quantity: order.Quantity}
+		var stock, grams int
+		var taxClass string
+		err := queryRowContext(ctx, tx, getStockQuery, order.ProductID).Scan(&stock, &line.UnitPrice, &line.Category, &grams, &taxClass)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("%w: id %d", structTypes.ErrProductNotFound, order.ProductID)
+		}
 		if err != nil {
-			return err
+			return 0, err
 		}
+		inCurrency(s.base(), &line.UnitPrice)
 
 		if stock <= 0 {
-			return fmt.Errorf("product_id %d is out of stock", order.ProductID)
+			return 0, fmt.Errorf("%w: product_id %d", structTypes.ErrOutOfStock, order.ProductID)
 		}
 		if stock < order.Quantity {
-			return fmt.Errorf("not enough stock for product_id %d (available: %d, requested: %d)",
-				order.ProductID, stock, order.Quantity)
+			return 0, fmt.Errorf("%w for product_id %d (available: %d, requested: %d)",
+				structTypes.ErrInsufficientStock, order.ProductID, stock, order.Quantity)
 		}
 
-		res, err := s.DB.Exec(updateQuery, order.Quantity, order.ProductID)
+		res, err := execContext(ctx, tx, updateQuery, order.Quantity, order.ProductID)
+		if err != nil {
+			return 0, err
+		}
+		rowsAffected, err := res.RowsAffected()
 		if err != nil {
-			return err
+			return 0, err
 		}
-		rowsAffected, _ := res.RowsAffected()
 		if rowsAffected == 0 {
-			return fmt.Errorf("not enough stock for product_id %d", order.ProductID)
+			return 0, fmt.Errorf("%w for product_id %d", structTypes.ErrInsufficientStock, order.ProductID)
 		}
+		price := conv.Price(line.ProductID, line.UnitPrice)
+		subtotal = subtotal.Add(price.Mul(line.Quantity))
+		baseSubtotal = baseSubtotal.Add(line.Total())
+		weight += grams * order.Quantity
+		lines = append(lines, line)
+		prices = append(prices, price)
+		priced = append(priced, checkoutLine{ProductID: line.ProductID, TaxClass: taxClass, Amount: price.Mul(line.Quantity)})
+	}
 
-		_, err = s.DB.Exec(insertQuery, userID, order.ProductID, order.Quantity, order.Price)
+	var orderID int
+	if err := queryRowContext(ctx, tx, insertOrderQuery, userID, subtotal, shipTo, billTo, conv.Currency).Scan(&orderID); err != nil {
+		return 0, err
+	}
+	itemIDs := make([]int, len(lines))
+	for i, line := range lines {
+		err = queryRowContext(ctx, tx, insertItemQuery, orderID, line.ProductID, line.Quantity, prices[i]).Scan(&itemIDs[i])
 		if err != nil {
-			return err
+			return 0, err
 		}
 	}
 
-	return nil
-}
-
-func (s *PostgresStore) GetAllOrdersByUserID(userID int) ([]structTypes.OrderResponse, error) {
-	var orders []structTypes.OrderResponse
+	applied, err := redeemCartPromotion(ctx, tx, userID, orderID, lines, s.base())
+	if err != nil {
+		return 0, err
+	}
+	costs, err := s.priceCheckout(ctx, tx, conv, shipping, options.ShippingMethod, priced, weight, baseSubtotal, applied)
+	if err != nil {
+		return 0, err
+	}
+	for i, line := range costs.Tax.Lines {
+		query := `UPDATE order_items SET tax_amount = $2, tax_rate_bps = $3 WHERE id = $1`
+		if _, err := execContext(ctx, tx, query, itemIDs[i], line.Tax, line.RateBasisPoints); err != nil {
+			return 0, err
+		}
+	}
+	total := subtotal.Sub(costs.Discount).Add(costs.Shipping)
+	if !s.taxInclusive {
+		total = total.Add(costs.Tax.Total)
+	}
 
 	query := `
-		SELECT 
-			o.id, o.user_id, o.product_id, 
-			p.name, p.description,
-			o.quantity, o.price, o.status, o.created_at
-		FROM orders o
-		JOIN products p ON o.product_id = p.id
-		WHERE o.user_id = $1
-	`
-	rows, err := s.DB.Query(query, userID)
+		UPDATE orders
+		SET discount = $2, promotion_code = NULLIF($3, ''), shipping_method = NULLIF($4, ''),
+			shipping_cost = $5, tax_total = $6, prices_include_tax = $7, total = $8, exchange_rate = $9
+		WHERE id = $1`
+	_, err = execContext(ctx, tx, query, orderID, costs.Discount, applied.Code, costs.Method, costs.Shipping, costs.Tax.Total, s.taxInclusive, total, conv.Rate)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	defer rows.Close()
 
+	return orderID, tx.Commit()
+}
+
+const orderSelect = `
+		SELECT 
+			o.id, o.user_id, o.discount, COALESCE(o.promotion_code, ''),
+			COALESCE(o.shipping_method, ''), o.shipping_cost, o.tax_total, o.prices_include_tax, o.total, o.currency, o.exchange_rate, o.status, o.created_at,
+			o.cancelled_at, COALESCE(o.cancel_reason, ''), COALESCE(o.cancelled_by, ''),
+			o.shipping_address, o.billing_address,
+			oi.id, oi.product_id, p.name, p.description,
+			oi.quantity, oi.price, oi.tax_amount, oi.tax_rate_bps
+		FROM orders o
+		JOIN order_items oi ON oi.order_id = o.id
+		JOIN products p ON oi.product_id = p.id`
+
+// scanOrders folds the one-row-per-item result of orderSelect back into
+// orders, keeping the order they were returned in.
+func scanOrders(rows *sql.Rows) ([]structTypes.OrderResponse, error) {
+	var orders []structTypes.OrderResponse
+	index := map[int]int{}
 	for rows.Next() {
 		var order structTypes.OrderResponse
+		var item structTypes.OrderItemResponse
+		var shipTo, billTo []byte
 		if err := rows.Scan(
 			&order.ID,
 			&order.UserID,
-			&order.ProductID,
-			&order.ProductName,
-			&order.Description,
-			&order.Quantity,
-			&order.Price,
+			&order.Discount,
+			&order.PromotionCode,
+			&order.ShippingMethod,
+			&order.ShippingCost,
+			&order.Tax,
+			&order.TaxIncluded,
+			&order.Total,
+			&order.Currency,
+			&order.ExchangeRate,
 			&order.Status,
 			&order.CreatedAt,
+			&order.CancelledAt,
+			&order.CancelReason,
+			&order.CancelledBy,
+			&shipTo,
+			&billTo,
+			&item.ID,
+			&item.ProductID,
+			&item.ProductName,
+			&item.Description,
+			&item.Quantity,
+			&item.Price,
+			&item.TaxAmount,
+			&item.TaxRate,
 		); err != nil {
 			return nil, err
 		}
-		orders = append(orders, order)
+		inCurrency(order.Currency, &order.Discount, &order.ShippingCost, &order.Tax, &order.Total, &item.Price, &item.TaxAmount)
+		i, ok := index[order.ID]
+		if !ok {
+			if shipTo != nil {
+				if err := json.Unmarshal(shipTo, &order.ShippingTo); err != nil {
+					return nil, err
+				}
+			}
+			if billTo != nil {
+				if err := json.Unmarshal(billTo, &order.BillingTo); err != nil {
+					return nil, err
+				}
+			}
+			i = len(orders)
+			index[order.ID] = i
+			orders = append(orders, order)
+		}
+		orders[i].Items = append(orders[i].Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (s *PostgresStore) GetAllOrdersByUserID(ctx context.Context, userID int) ([]structTypes.OrderResponse, error) {
+	ctx, span := startMethodSpan(ctx, "GetAllOrdersByUserID")
+	defer span.End()
+
+	if err := userExists(ctx, s.DB, userID); err != nil {
+		return nil, err
+	}
+
+	query := orderSelect + `
+		WHERE o.user_id = $1
+		ORDER BY o.created_at DESC, o.id DESC, oi.id
+	`
+	rows, err := queryContext(ctx, s.DB, query, userID)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
+	orders, err := scanOrders(rows)
+	if err != nil {
+		return nil, err
+	}
+	if orders == nil {
+		orders = []structTypes.OrderResponse{}
+	}
 	return orders, nil
 }
 
-func (s *PostgresStore) GetOrderByID(orderID int) (structTypes.OrderResponse, error) {
+func (s *PostgresStore) GetOrderByID(ctx context.Context, orderID int) (structTypes.OrderResponse, error) {
+	ctx, span := startMethodSpan(ctx, "GetOrderByID")
+	defer span.End()
 	var order structTypes.OrderResponse
 
-	query := `
-		SELECT 
-			o.id, o.user_id, o.product_id, 
-			p.name, p.description,
-			o.quantity, o.price, o.status, o.created_at
-		FROM orders o
-		JOIN products p ON o.product_id = p.id
+	query := orderSelect + `
 		WHERE o.id = $1
+		ORDER BY oi.id
 	`
-	row := s.DB.QueryRow(query, orderID)
+	rows, err := queryContext(ctx, s.DB, query, orderID)
+	if err != nil {
+		return order, err
+	}
+	defer rows.Close()
 
-	err := row.Scan(
-		&order.ID,
-		&order.UserID,
-		&order.ProductID,
-		&order.ProductName,
-		&order.Description,
-		&order.Quantity,
-		&order.Price,
-		&order.Status,
-		&order.CreatedAt,
-	)
+	orders, err := scanOrders(rows)
+	if err != nil {
+		return order, err
+	}
+	if len(orders) == 0 {
+		return order, fmt.Errorf("%w: id %d", structTypes.ErrOrderNotFound, orderID)
+	}
+	order = orders[0]
+	order.Shipments, err = orderShipments(ctx, s.DB, orderID)
 	if err != nil {
 		return order, err
 	}
@@ -384,107 +1018,132 @@ func (s *PostgresStore) GetOrderByID(orderID int) (structTypes.OrderResponse, er
 	return order, nil
 }
 
-func (s *PostgresStore) UpdateOrderStatus(orderID int, status string) error {
+func (s *PostgresStore) UpdateOrderStatus(ctx context.Context, orderID int, status string) error {
+	ctx, span := startMethodSpan(ctx, "UpdateOrderStatus")
+	defer span.End()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the current status is read under lock so only shipped orders become
+	// delivered, which is what opens returns and verified reviews
+	var current string
+	err = queryRowContext(ctx, tx, `SELECT status FROM orders WHERE id = $1 FOR UPDATE`, orderID).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: id %d", structTypes.ErrOrderNotFound, orderID)
+	}
+	if err != nil {
+		return err
+	}
+	if !structTypes.CanTransitionOrder(current, status) {
+		return fmt.Errorf("%w: order %d is %s, not %s", structTypes.ErrOrderTransition, orderID, current, status)
+	}
+
 	query := `
 		UPDATE orders
-		SET status = $1
+		SET status = $1,
+			delivered_at = CASE WHEN $1 = 'delivered' THEN COALESCE(delivered_at, now()) ELSE delivered_at END
 		WHERE id = $2
 	`
-	_, err := s.DB.Exec(query, status, orderID)
-	if err != nil {
+	if _, err := execContext(ctx, tx, query, status, orderID); err != nil {
 		return err
 	}
+	return tx.Commit()
+}
 
-	return nil
+// restockOrder puts the quantity of every item of the order back in stock.
+func restockOrder(ctx context.Context, tx dbtx, orderID int) error {
+	query := `
+		UPDATE products p
+		SET stock = p.stock + oi.quantity
+		FROM order_items oi
+		WHERE oi.product_id = p.id AND oi.order_id = $1
+	`
+	_, err := execContext(ctx, tx, query, orderID)
+	return err
 }
 
-func (s *PostgresStore) DeleteOrder(orderID int) error {
-	// Step 1: get product_id and quantity of the order
-	var productID, quantity int
-	queryGet := `SELECT product_id, quantity FROM orders WHERE id = $1`
-	err := s.DB.QueryRow(queryGet, orderID).Scan(&productID, &quantity)
+// CancelOrder puts the stock of every item back, frees any promotion it
+// redeemed and marks the order cancelled, keeping the order for reporting.
+// Orders that have shipped can't be cancelled; they go through returns.
+func (s *PostgresStore) CancelOrder(ctx context.Context, orderID int, reason, actor string) error {
+	ctx, span := startMethodSpan(ctx, "CancelOrder")
+	defer span.End()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	updateProductQuery := `UPDATE products SET stock = stock + $1 WHERE id = $2`
-	_, err = s.DB.Exec(updateProductQuery, quantity, productID)
+	var status string
+	query := `SELECT status FROM orders WHERE id = $1 FOR UPDATE`
+	err = queryRowContext(ctx, tx, query, orderID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: id %d", structTypes.ErrOrderNotFound, orderID)
+	}
 	if err != nil {
 		return err
 	}
+	if !structTypes.OrderCancellable(status) {
+		return fmt.Errorf("%w: order %d is %s", structTypes.ErrOrderNotCancellable, orderID, status)
+	}
 
-	deleteQuery := `DELETE FROM orders WHERE id = $1`
-	_, err = s.DB.Exec(deleteQuery, orderID)
-	if err != nil {
+	if err := restockOrder(ctx, tx, orderID); err != nil {
+		return err
+	}
+	if err := releaseRedemptions(ctx, tx, orderID); err != nil {
+		return err
+	}
+	query = `
+		UPDATE orders
+		SET status = $2, cancelled_at = now(), cancel_reason = $3, cancelled_by = $4
+		WHERE id = $1
+	`
+	if _, err := execContext(ctx, tx, query, orderID, structTypes.OrderCancelled, reason, actor); err != nil {
 		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
 // REVIEWS FUNCTIONS
 
-func (s *PostgresStore) CreateNewReview(review structTypes.ReviewRequest) error {
-	query := "insert into reviews (user_id, product_id, rating, comment) values ($1, $2, $3, $4);"
-	_, err := s.DB.Exec(query, review.UserID, review.ProductID, review.Rating, review.Comment)
+// CreateNewReview writes the user's review of the product. A user has one
+// review per product, so reviewing it again edits that review; created
+// tells which happened.
+func (s *PostgresStore) CreateNewReview(ctx context.Context, review structTypes.ReviewRequest) (bool, error) {
+	ctx, span := startMethodSpan(ctx, "CreateNewReview")
+	defer span.End()
+	tx, err := s.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return false, err
 	}
-	return nil
-}
+	defer tx.Rollback()
 
-func (s *PostgresStore) GetAllReviewsByProductID(productID int) ([]structTypes.ReviewResponse, error) {
-	var reviews []structTypes.ReviewResponse
-	query := `SELECT 
-			p.id AS product_id,
-			p.name AS product_name,
-			p.description AS product_description,
-			p.price,
-			p.stock,
-			p.created_at AS product_created_at,
-			
-			r.id AS review_id,
-			r.rating,
-			r.comment,
-			r.created_at AS review_created_at,
-			
-			u.id AS user_id,
-			u.username,
-			u.email
-		FROM products p
-		LEFT JOIN reviews r ON p.id = r.product_id
-		LEFT JOIN users u ON r.user_id = u.id
-		WHERE p.id = $1;
-		`
-	data, err := s.DB.Query(query, productID)
+	var exists bool
+	if err := queryRowContext(ctx, tx, `SELECT EXISTS (SELECT 1 FROM products WHERE id = $1)`, review.ProductID).Scan(&exists); err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, fmt.Errorf("%w: id %d", structTypes.ErrProductNotFound, review.ProductID)
+	}
+	query := `insert into reviews (user_id, product_id, rating, comment, status, moderation_note)
+		values ($1, $2, $3, $4, $5, NULLIF($6, ''))
+		on conflict (user_id, product_id) do update
+		set rating = excluded.rating, comment = excluded.comment, updated_at = now(),
+			status = excluded.status, moderation_note = excluded.moderation_note, moderated_at = NULL
+		returning xmax = 0;`
+	var created bool
+	err = queryRowContext(ctx, tx, query, review.UserID, review.ProductID, review.Rating, review.Comment, review.Status, review.ModerationNote).Scan(&created)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	defer data.Close()
-	for data.Next() {
-		var review structTypes.ReviewResponse
-		err := data.Scan(
-			&review.Product.ID,
-			&review.Product.Name,
-			&review.Product.Description,
-			&review.Product.Price,
-			&review.Product.Stock,
-			&review.Product.Created_at,
-
-			&review.ID,
-			&review.Rating,
-			&review.Comment,
-			&review.CreatedAt,
-
-			&review.User.ID,
-			&review.User.Username,
-			&review.User.Email,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		reviews = append(reviews, review)
+	if err := refreshProductRating(ctx, tx, review.ProductID); err != nil {
+		return false, err
 	}
-	return reviews, nil
+	return created, tx.Commit()
 }
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+// idempotencyLockTimeout is how long a key stays locked by a request that
+// never completed, e.g. because the process died while serving it.
+const idempotencyLockTimeout = time.Minute
+
+func (s *PostgresStore) initIdempotency() error {
+	query := `create table if not exists idempotency_keys (
+		key TEXT PRIMARY KEY,
+		fingerprint TEXT NOT NULL,
+		status INT,
+		response BYTEA,
+		created_at TIMESTAMP NOT NULL DEFAULT now()
+		);`
+	_, err := s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	// response headers a replay needs, such as a new guest cart's token
+	query = `alter table idempotency_keys add column if not exists headers JSONB;`
+	_, err = s.DB.Exec(query)
+	return err
+}
+
+// IDEMPOTENCY FUNCTIONS
+
+// BeginIdempotentRequest claims key for a request with the given
+// fingerprint. It reports true when the caller should go on and serve the
+// request. Otherwise the stored record is returned: a completed one is to be
+// replayed, and a key already used for another fingerprint or still in
+// progress is a conflict. Keys created before expiredBefore count as unused.
+func (s *PostgresStore) BeginIdempotentRequest(ctx context.Context, key, fingerprint string, expiredBefore time.Time) (structTypes.IdempotencyRecord, bool, error) {
+	ctx, span := startMethodSpan(ctx, "BeginIdempotentRequest")
+	defer span.End()
+	record := structTypes.IdempotencyRecord{Key: key, Fingerprint: fingerprint}
+
+	query := `
+		INSERT INTO idempotency_keys (key, fingerprint)
+		VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE
+			SET fingerprint = EXCLUDED.fingerprint, status = NULL, response = NULL, headers = NULL, created_at = now()
+			WHERE idempotency_keys.created_at < $3
+				OR (idempotency_keys.status IS NULL AND idempotency_keys.created_at < $4)
+		RETURNING created_at`
+	err := queryRowContext(ctx, s.DB, query, key, fingerprint, expiredBefore, time.Now().Add(-idempotencyLockTimeout)).Scan(&record.CreatedAt)
+	if err == nil {
+		return record, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return record, false, err
+	}
+
+	var status sql.NullInt64
+	var headers []byte
+	query = `SELECT fingerprint, status, response, headers, created_at FROM idempotency_keys WHERE key = $1`
+	err = queryRowContext(ctx, s.DB, query, key).Scan(&record.Fingerprint, &status, &record.Body, &headers, &record.CreatedAt)
+	if err != nil {
+		return record, false, err
+	}
+	if headers != nil {
+		if err := json.Unmarshal(headers, &record.Header); err != nil {
+			return record, false, err
+		}
+	}
+	if record.Fingerprint != fingerprint {
+		return record, false, structTypes.ErrIdempotencyKeyReused
+	}
+	if !status.Valid {
+		return record, false, structTypes.ErrIdempotencyKeyInFlight
+	}
+	record.Completed = true
+	record.Status = int(status.Int64)
+	return record, false, nil
+}
+
+// CompleteIdempotentRequest stores the response served for key.
+func (s *PostgresStore) CompleteIdempotentRequest(ctx context.Context, key string, status int, header http.Header, body []byte) error {
+	ctx, span := startMethodSpan(ctx, "CompleteIdempotentRequest")
+	defer span.End()
+	headers, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE idempotency_keys SET status = $2, response = $3, headers = $4 WHERE key = $1`
+	_, err = execContext(ctx, s.DB, query, key, status, body, headers)
+	return err
+}
+
+// ReleaseIdempotentRequest forgets key so the request can be retried.
+func (s *PostgresStore) ReleaseIdempotentRequest(ctx context.Context, key string) error {
+	ctx, span := startMethodSpan(ctx, "ReleaseIdempotentRequest")
+	defer span.End()
+	_, err := execContext(ctx, s.DB, `DELETE FROM idempotency_keys WHERE key = $1`, key)
+	return err
+}
+
+// PurgeIdempotencyKeys deletes keys created before the given time.
+func (s *PostgresStore) PurgeIdempotencyKeys(ctx context.Context, before time.Time) (int, error) {
+	ctx, span := startMethodSpan(ctx, "PurgeIdempotencyKeys")
+	defer span.End()
+	res, err := execContext(ctx, s.DB, `DELETE FROM idempotency_keys WHERE created_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,209 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/VincentSamuelPaul/production-api/payments"
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+func (s *PostgresStore) initPayments() error {
+	query := `create table if not exists payments (
+		id SERIAL PRIMARY KEY,
+		order_id INT NOT NULL REFERENCES orders(id),
+		provider TEXT NOT NULL,
+		reference TEXT NOT NULL,
+		status TEXT NOT NULL,
+		amount NUMERIC(13,3) NOT NULL,
+		captured_amount NUMERIC(13,3) NOT NULL DEFAULT 0,
+		refunded_amount NUMERIC(13,3) NOT NULL DEFAULT 0,
+		failure_reason TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT now(),
+		updated_at TIMESTAMP DEFAULT now(),
+		UNIQUE (provider, reference)
+		);`
+	_, err := s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	// every event applied, so a webhook redelivery is only applied once
+	query = `create table if not exists payment_events (
+		provider TEXT NOT NULL,
+		event_id TEXT NOT NULL,
+		reference TEXT NOT NULL,
+		status TEXT NOT NULL,
+		amount NUMERIC(13,3) NOT NULL,
+		received_at TIMESTAMP DEFAULT now(),
+		PRIMARY KEY (provider, event_id)
+		);`
+	_, err = s.DB.Exec(query)
+	return err
+}
+
+// PAYMENT FUNCTIONS
+
+// payments are in the currency of their order
+const paymentColumns = `id, order_id, provider, reference, status, amount, captured_amount,
+		refunded_amount, failure_reason, created_at, updated_at,
+		(SELECT currency FROM orders WHERE orders.id = payments.order_id)`
+
+func scanPayment(row interface{ Scan(...any) error }, p *structTypes.Payment) error {
+	err := row.Scan(&p.ID, &p.OrderID, &p.Provider, &p.Reference, &p.Status, &p.Amount, &p.CapturedAmount,
+		&p.RefundedAmount, &p.FailureReason, &p.CreatedAt, &p.UpdatedAt, &p.Currency)
+	inCurrency(p.Currency, &p.Amount, &p.CapturedAmount, &p.RefundedAmount)
+	return err
+}
+
+// ApplyPaymentEvent records an outcome reported by the provider and moves
+// the order to the matching status while the order is still waiting on its
+// payment; shipped and cancelled orders keep their status. Events carrying an ID already applied
+// are ignored and the payment is returned as it is. An event naming a
+// different order than the payment it references is refused. When the
+// payment fails or is voided the order's stock and any promotion it
+// redeemed are released.
+func (s *PostgresStore) ApplyPaymentEvent(ctx context.Context, provider string, event structTypes.PaymentEvent) (structTypes.Payment, error) {
+	ctx, span := startMethodSpan(ctx, "ApplyPaymentEvent")
+	defer span.End()
+	var payment structTypes.Payment
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return payment, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT ` + paymentColumns + ` FROM payments WHERE provider = $1 AND reference = $2 FOR UPDATE`
+	err = scanPayment(queryRowContext(ctx, tx, query, provider, event.Reference), &payment)
+	known := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return payment, err
+	}
+	if known && event.OrderID != 0 && event.OrderID != payment.OrderID {
+		return payment, fmt.Errorf("%w: reference %s is for order %d, not %d",
+			structTypes.ErrPaymentReferenceConflict, event.Reference, payment.OrderID, event.OrderID)
+	}
+
+	if event.ID != "" {
+		query = `INSERT INTO payment_events (provider, event_id, reference, status, amount)
+			VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING`
+		res, err := execContext(ctx, tx, query, provider, event.ID, event.Reference, event.Status, event.Amount)
+		if err != nil {
+			return payment, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return payment, err
+		} else if n == 0 {
+			if known {
+				return payment, nil
+			}
+			// a concurrent delivery of the same event created the payment
+			query = `SELECT ` + paymentColumns + ` FROM payments WHERE provider = $1 AND reference = $2`
+			err = scanPayment(queryRowContext(ctx, tx, query, provider, event.Reference), &payment)
+			if errors.Is(err, sql.ErrNoRows) {
+				return payment, fmt.Errorf("%w: reference %s", structTypes.ErrPaymentNotFound, event.Reference)
+			}
+			if err == nil && event.OrderID != 0 && event.OrderID != payment.OrderID {
+				err = fmt.Errorf("%w: reference %s is for order %d, not %d",
+					structTypes.ErrPaymentReferenceConflict, event.Reference, payment.OrderID, event.OrderID)
+			}
+			return payment, err
+		}
+	}
+
+	if !known {
+		if event.OrderID == 0 {
+			return payment, fmt.Errorf("%w: reference %s", structTypes.ErrPaymentNotFound, event.Reference)
+		}
+		payment = structTypes.Payment{OrderID: event.OrderID, Provider: provider, Reference: event.Reference}
+	}
+	next, err := payments.Transition(payment, event)
+	if err != nil {
+		return payment, err
+	}
+
+	var orderStatus string
+	query = `SELECT status FROM orders WHERE id = $1 FOR UPDATE`
+	err = queryRowContext(ctx, tx, query, next.OrderID).Scan(&orderStatus)
+	if errors.Is(err, sql.ErrNoRows) {
+		return payment, fmt.Errorf("%w: id %d", structTypes.ErrOrderNotFound, next.OrderID)
+	}
+	if err != nil {
+		return payment, err
+	}
+
+	if known {
+		query = `UPDATE payments
+			SET status = $2, amount = $3, captured_amount = $4, refunded_amount = $5,
+				failure_reason = $6, updated_at = now()
+			WHERE id = $1
+			RETURNING ` + paymentColumns
+		err = scanPayment(queryRowContext(ctx, tx, query, next.ID, next.Status, next.Amount,
+			next.CapturedAmount, next.RefundedAmount, next.FailureReason), &payment)
+	} else {
+		query = `INSERT INTO payments (order_id, provider, reference, status, amount, captured_amount,
+				refunded_amount, failure_reason)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING ` + paymentColumns
+		err = scanPayment(queryRowContext(ctx, tx, query, next.OrderID, next.Provider, next.Reference, next.Status,
+			next.Amount, next.CapturedAmount, next.RefundedAmount, next.FailureReason), &payment)
+	}
+	if err != nil {
+		return payment, err
+	}
+
+	status := payments.OrderStatus(payment)
+	if !payments.SetsOrderStatus(orderStatus) || status == orderStatus {
+		return payment, tx.Commit()
+	}
+	if _, err := execContext(ctx, tx, `UPDATE orders SET status = $2 WHERE id = $1`, payment.OrderID, status); err != nil {
+		return payment, err
+	}
+	if orderStatus != structTypes.OrderPaymentFailed && (status == structTypes.OrderPaymentFailed || status == structTypes.OrderCancelled) {
+		if err := restockOrder(ctx, tx, payment.OrderID); err != nil {
+			return payment, err
+		}
+		if err := releaseRedemptions(ctx, tx, payment.OrderID); err != nil {
+			return payment, err
+		}
+	}
+
+	return payment, tx.Commit()
+}
+
+// GetPayment finds the payment the provider knows by reference.
+func (s *PostgresStore) GetPayment(ctx context.Context, provider, reference string) (structTypes.Payment, error) {
+	ctx, span := startMethodSpan(ctx, "GetPayment")
+	defer span.End()
+	var payment structTypes.Payment
+	query := `SELECT ` + paymentColumns + ` FROM payments WHERE provider = $1 AND reference = $2`
+	err := scanPayment(queryRowContext(ctx, s.DB, query, provider, reference), &payment)
+	if errors.Is(err, sql.ErrNoRows) {
+		return payment, fmt.Errorf("%w: reference %s", structTypes.ErrPaymentNotFound, reference)
+	}
+	return payment, err
+}
+
+func (s *PostgresStore) GetPaymentsByOrderID(ctx context.Context, orderID int) ([]structTypes.Payment, error) {
+	ctx, span := startMethodSpan(ctx, "GetPaymentsByOrderID")
+	defer span.End()
+
+	query := `SELECT ` + paymentColumns + ` FROM payments WHERE order_id = $1 ORDER BY id`
+	rows, err := queryContext(ctx, s.DB, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []structTypes.Payment{}
+	for rows.Next() {
+		var p structTypes.Payment
+		if err := scanPayment(rows, &p); err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, rows.Err()
+}
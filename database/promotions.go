@@ -0,0 +1,330 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/VincentSamuelPaul/production-api/promotions"
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+	"github.com/lib/pq"
+)
+
+func (s *PostgresStore) initPromotions() error {
+	query := `alter table products add column if not exists category TEXT;`
+	_, err := s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	query = `create table if not exists promotions (
+		id SERIAL PRIMARY KEY,
+		code TEXT UNIQUE NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		kind TEXT NOT NULL,
+		percent_bps INT NOT NULL DEFAULT 0,
+		amount_off NUMERIC(13,3) NOT NULL DEFAULT 0,
+		buy_quantity INT NOT NULL DEFAULT 0,
+		get_quantity INT NOT NULL DEFAULT 0,
+		min_order NUMERIC(13,3) NOT NULL DEFAULT 0,
+		max_uses INT,
+		max_uses_per_user INT,
+		starts_at TIMESTAMP,
+		ends_at TIMESTAMP,
+		product_ids INT[] NOT NULL DEFAULT '{}',
+		categories TEXT[] NOT NULL DEFAULT '{}',
+		active BOOLEAN NOT NULL DEFAULT true,
+		created_at TIMESTAMP DEFAULT now()
+		);`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	// the code currently applied to a cart, evaluated again at checkout
+	query = `create table if not exists cart_promotions (
+		cart_id INT PRIMARY KEY REFERENCES carts(id) ON DELETE CASCADE,
+		promotion_id INT NOT NULL REFERENCES promotions(id),
+		applied_at TIMESTAMP DEFAULT now()
+		);`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	query = `alter table orders
+		add column if not exists subtotal NUMERIC(13,3),
+		add column if not exists discount NUMERIC(13,3) NOT NULL DEFAULT 0,
+		add column if not exists promotion_code TEXT;`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	// audit of every redemption, also the source of usage counts
+	query = `create table if not exists promotion_redemptions (
+		id SERIAL PRIMARY KEY,
+		promotion_id INT NOT NULL REFERENCES promotions(id),
+		order_id INT NOT NULL REFERENCES orders(id),
+		user_id INT NOT NULL REFERENCES users(id),
+		code TEXT NOT NULL,
+		discount NUMERIC(13,3) NOT NULL,
+		free_shipping BOOLEAN NOT NULL,
+		redeemed_at TIMESTAMP DEFAULT now()
+		);`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+const promotionColumns = `pr.id, pr.code, pr.description, pr.kind, pr.percent_bps, pr.amount_off,
+	pr.buy_quantity, pr.get_quantity, pr.min_order, pr.max_uses, pr.max_uses_per_user,
+	pr.starts_at, pr.ends_at, pr.product_ids, pr.categories, pr.active, pr.created_at`
+
+// scanPromotion reads a promotion whose amounts are in the base currency c.
+func scanPromotion(row interface{ Scan(...any) error }, promo *structTypes.Promotion, c structTypes.Currency) error {
+	var productIDs pq.Int64Array
+	var categories pq.StringArray
+	var maxUses, maxUsesPerUser sql.NullInt64
+	var startsAt, endsAt sql.NullTime
+	err := row.Scan(
+		&promo.ID,
+		&promo.Code,
+		&promo.Description,
+		&promo.Kind,
+		&promo.PercentBasisPoints,
+		&promo.AmountOff,
+		&promo.BuyQuantity,
+		&promo.GetQuantity,
+		&promo.MinOrder,
+		&maxUses,
+		&maxUsesPerUser,
+		&startsAt,
+		&endsAt,
+		&productIDs,
+		&categories,
+		&promo.Active,
+		&promo.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	inCurrency(c, &promo.AmountOff, &promo.MinOrder)
+	if maxUses.Valid {
+		n := int(maxUses.Int64)
+		promo.MaxUses = &n
+	}
+	if maxUsesPerUser.Valid {
+		n := int(maxUsesPerUser.Int64)
+		promo.MaxUsesPerUser = &n
+	}
+	if startsAt.Valid {
+		promo.StartsAt = &startsAt.Time
+	}
+	if endsAt.Valid {
+		promo.EndsAt = &endsAt.Time
+	}
+	promo.ProductIDs = []int{}
+	for _, id := range productIDs {
+		promo.ProductIDs = append(promo.ProductIDs, int(id))
+	}
+	promo.Categories = []string(categories)
+	if promo.Categories == nil {
+		promo.Categories = []string{}
+	}
+	return nil
+}
+
+// normalizeCode makes codes case insensitive.
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func (s *PostgresStore) CreatePromotion(ctx context.Context, promo structTypes.Promotion) (structTypes.Promotion, error) {
+	ctx, span := startMethodSpan(ctx, "CreatePromotion")
+	defer span.End()
+	var created structTypes.Promotion
+	productIDs := make(pq.Int64Array, 0, len(promo.ProductIDs))
+	for _, id := range promo.ProductIDs {
+		productIDs = append(productIDs, int64(id))
+	}
+	categories := pq.StringArray(promo.Categories)
+	if categories == nil {
+		categories = pq.StringArray{}
+	}
+	query := `
+		INSERT INTO promotions AS pr (code, description, kind, percent_bps, amount_off, buy_quantity, get_quantity,
+			min_order, max_uses, max_uses_per_user, starts_at, ends_at, product_ids, categories, active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		RETURNING ` + promotionColumns
+	err := scanPromotion(queryRowContext(ctx, s.DB, query,
+		normalizeCode(promo.Code),
+		promo.Description,
+		promo.Kind,
+		promo.PercentBasisPoints,
+		promo.AmountOff,
+		promo.BuyQuantity,
+		promo.GetQuantity,
+		promo.MinOrder,
+		promo.MaxUses,
+		promo.MaxUsesPerUser,
+		promo.StartsAt,
+		promo.EndsAt,
+		productIDs,
+		categories,
+		promo.Active,
+	), &created, s.base())
+	return created, err
+}
+
+func (s *PostgresStore) GetPromotions(ctx context.Context) ([]structTypes.Promotion, error) {
+	ctx, span := startMethodSpan(ctx, "GetPromotions")
+	defer span.End()
+	rows, err := queryContext(ctx, s.DB, `SELECT `+promotionColumns+` FROM promotions pr ORDER BY pr.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	promos := []structTypes.Promotion{}
+	for rows.Next() {
+		var promo structTypes.Promotion
+		if err := scanPromotion(rows, &promo, s.base()); err != nil {
+			return nil, err
+		}
+		promos = append(promos, promo)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return promos, nil
+}
+
+func (s *PostgresStore) SetPromotionActive(ctx context.Context, id int, active bool) error {
+	ctx, span := startMethodSpan(ctx, "SetPromotionActive")
+	defer span.End()
+	res, err := execContext(ctx, s.DB, `UPDATE promotions SET active = $2 WHERE id = $1`, id, active)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%w: id %d", structTypes.ErrPromotionNotFound, id)
+	}
+	return nil
+}
+
+func (s *PostgresStore) GetPromotionByCode(ctx context.Context, code string) (structTypes.Promotion, error) {
+	ctx, span := startMethodSpan(ctx, "GetPromotionByCode")
+	defer span.End()
+	var promo structTypes.Promotion
+	query := `SELECT ` + promotionColumns + ` FROM promotions pr WHERE pr.code = $1`
+	err := scanPromotion(queryRowContext(ctx, s.DB, query, normalizeCode(code)), &promo, s.base())
+	if errors.Is(err, sql.ErrNoRows) {
+		return promo, fmt.Errorf("%w: code %s", structTypes.ErrPromotionNotFound, normalizeCode(code))
+	}
+	return promo, err
+}
+
+// GetPromotionUsage returns the redemption count overall and by the user.
+func (s *PostgresStore) GetPromotionUsage(ctx context.Context, promotionID, userID int) (int, int, error) {
+	ctx, span := startMethodSpan(ctx, "GetPromotionUsage")
+	defer span.End()
+	return promotionUsage(ctx, s.DB, promotionID, userID)
+}
+
+func promotionUsage(ctx context.Context, db dbtx, promotionID, userID int) (int, int, error) {
+	var total, byUser int
+	query := `SELECT COUNT(*), COUNT(*) FILTER (WHERE user_id = $2) FROM promotion_redemptions WHERE promotion_id = $1`
+	err := queryRowContext(ctx, db, query, promotionID, userID).Scan(&total, &byUser)
+	return total, byUser, err
+}
+
+// GetCartPromotion returns the promotion applied to the cart, or nil.
+func (s *PostgresStore) GetCartPromotion(ctx context.Context, cartID int) (*structTypes.Promotion, error) {
+	ctx, span := startMethodSpan(ctx, "GetCartPromotion")
+	defer span.End()
+	return cartPromotion(ctx, s.DB, cartID, false, s.base())
+}
+
+func cartPromotion(ctx context.Context, db dbtx, cartID int, lock bool, base structTypes.Currency) (*structTypes.Promotion, error) {
+	query := `SELECT ` + promotionColumns + ` FROM cart_promotions cp
+		JOIN promotions pr ON pr.id = cp.promotion_id
+		WHERE cp.cart_id = $1`
+	if lock {
+		// serialises checkouts using the same code so usage limits hold
+		query += ` FOR UPDATE OF pr`
+	}
+	var promo structTypes.Promotion
+	err := scanPromotion(queryRowContext(ctx, db, query, cartID), &promo, base)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &promo, nil
+}
+
+func (s *PostgresStore) ApplyCartPromotion(ctx context.Context, cartID, promotionID int) error {
+	ctx, span := startMethodSpan(ctx, "ApplyCartPromotion")
+	defer span.End()
+	query := `
+		INSERT INTO cart_promotions (cart_id, promotion_id) VALUES ($1, $2)
+		ON CONFLICT (cart_id) DO UPDATE SET promotion_id = EXCLUDED.promotion_id, applied_at = now();
+	`
+	_, err := execContext(ctx, s.DB, query, cartID, promotionID)
+	return err
+}
+
+func (s *PostgresStore) RemoveCartPromotion(ctx context.Context, cartID int) error {
+	ctx, span := startMethodSpan(ctx, "RemoveCartPromotion")
+	defer span.End()
+	_, err := execContext(ctx, s.DB, `DELETE FROM cart_promotions WHERE cart_id = $1`, cartID)
+	return err
+}
+
+// releaseRedemptions drops the promotion redemptions of an order that was
+// cancelled or never paid, so the code's usage limits count it no more.
+func releaseRedemptions(ctx context.Context, tx dbtx, orderID int) error {
+	_, err := execContext(ctx, tx, `DELETE FROM promotion_redemptions WHERE order_id = $1`, orderID)
+	return err
+}
+
+// redeemCartPromotion evaluates the code applied to the user's cart against
+// the order lines and records the redemption. A code that is no longer
+// applicable fails the checkout rather than silently dropping the discount.
+func redeemCartPromotion(ctx context.Context, tx dbtx, userID, orderID int, lines []promotions.Line, base structTypes.Currency) (structTypes.AppliedPromotion, error) {
+	var applied structTypes.AppliedPromotion
+	cartID, err := cartIDForUser(ctx, tx, userID)
+	if errors.Is(err, structTypes.ErrCartNotFound) {
+		return applied, nil
+	}
+	if err != nil {
+		return applied, err
+	}
+	promo, err := cartPromotion(ctx, tx, cartID, true, base)
+	if err != nil || promo == nil {
+		return applied, err
+	}
+	total, byUser, err := promotionUsage(ctx, tx, promo.ID, userID)
+	if err != nil {
+		return applied, err
+	}
+	applied, err = promotions.Evaluate(*promo, lines, promotions.Usage{UserID: userID, Total: total, ByUser: byUser}, time.Now())
+	if err != nil {
+		return applied, err
+	}
+	query := `
+		INSERT INTO promotion_redemptions (promotion_id, order_id, user_id, code, discount, free_shipping)
+		VALUES ($1, $2, $3, $4, $5, $6);
+	`
+	if _, err := execContext(ctx, tx, query, promo.ID, orderID, userID, promo.Code, applied.Discount, applied.FreeShipping); err != nil {
+		return applied, err
+	}
+	if _, err := execContext(ctx, tx, `DELETE FROM cart_promotions WHERE cart_id = $1`, cartID); err != nil {
+		return applied, err
+	}
+	return applied, nil
+}
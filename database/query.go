@@ -0,0 +1,97 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+)
+
+// queryBuilder composes a SELECT from a fixed base statement plus optional
+// WHERE, ORDER BY and LIMIT/OFFSET clauses. Values are always bound as $n
+// parameters; only identifiers chosen by our own code end up in the SQL.
+type queryBuilder struct {
+	base    string
+	where   []string
+	orderBy []string
+	args    []any
+	limit   int
+	offset  int
+}
+
+func newQuery(base string) *queryBuilder {
+	return &queryBuilder{base: base}
+}
+
+// Where adds a condition joined with AND. Each ? in cond is replaced by the
+// next positional parameter, bound to the matching value in args.
+func (q *queryBuilder) Where(cond string, args ...any) *queryBuilder {
+	if strings.Count(cond, "?") != len(args) {
+		panic(fmt.Sprintf("queryBuilder: %q expects %d args, got %d", cond, strings.Count(cond, "?"), len(args)))
+	}
+	var b strings.Builder
+	for _, r := range cond {
+		if r == '?' {
+			q.args = append(q.args, args[0])
+			args = args[1:]
+			fmt.Fprintf(&b, "$%d", len(q.args))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	q.where = append(q.where, b.String())
+	return q
+}
+
+// OrderBy appends a sort key. column must come from code, never from
+// request input; use sortColumn to map user supplied keys.
+func (q *queryBuilder) OrderBy(column string, desc bool) *queryBuilder {
+	if desc {
+		column += " DESC"
+	} else {
+		column += " ASC"
+	}
+	q.orderBy = append(q.orderBy, column)
+	return q
+}
+
+func (q *queryBuilder) Limit(n int) *queryBuilder {
+	q.limit = n
+	return q
+}
+
+func (q *queryBuilder) Offset(n int) *queryBuilder {
+	q.offset = n
+	return q
+}
+
+func (q *queryBuilder) Build() (string, []any) {
+	var b strings.Builder
+	b.WriteString(q.base)
+	args := append([]any(nil), q.args...)
+	if len(q.where) > 0 {
+		b.WriteString(" WHERE ")
+		b.WriteString(strings.Join(q.where, " AND "))
+	}
+	if len(q.orderBy) > 0 {
+		b.WriteString(" ORDER BY ")
+		b.WriteString(strings.Join(q.orderBy, ", "))
+	}
+	if q.limit > 0 {
+		args = append(args, q.limit)
+		fmt.Fprintf(&b, " LIMIT $%d", len(args))
+	}
+	if q.offset > 0 {
+		args = append(args, q.offset)
+		fmt.Fprintf(&b, " OFFSET $%d", len(args))
+	}
+	return b.String(), args
+}
+
+// sortColumn resolves a user supplied sort key against an allow list of
+// column expressions.
+func sortColumn(key string, allowed map[string]string) (string, error) {
+	column, ok := allowed[key]
+	if !ok {
+		return "", fmt.Errorf("unsupported sort %q", key)
+	}
+	return column, nil
+}
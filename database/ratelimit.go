@@ -0,0 +1,53 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/VincentSamuelPaul/production-api/ratelimit"
+)
+
+// RateLimitStore keeps token buckets in the rate_limits table so that every
+// instance pointed at the same database enforces shared limits.
+type RateLimitStore struct {
+	DB *sql.DB
+}
+
+func NewRateLimitStore(db *sql.DB) *RateLimitStore {
+	return &RateLimitStore{DB: db}
+}
+
+// refilled is the bucket level after topping it up for the time elapsed
+// since the previous request, capped at the burst size ($2).
+const refilled = `LEAST($2::float8, rate_limits.tokens + EXTRACT(EPOCH FROM (now() - rate_limits.updated_at)) * $3::float8)`
+
+func (s *RateLimitStore) Take(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	query := `
+		INSERT INTO rate_limits (key, tokens, allowed, updated_at)
+		VALUES ($1, $2::float8 - 1, true, now())
+		ON CONFLICT (key) DO UPDATE SET
+			allowed = ` + refilled + ` >= 1,
+			tokens = ` + refilled + ` - CASE WHEN ` + refilled + ` >= 1 THEN 1 ELSE 0 END,
+			updated_at = now()
+		RETURNING allowed, tokens;
+	`
+	var allowed bool
+	var tokens float64
+	err := queryRowContext(ctx, s.DB, query, key, limit.Burst, limit.Rate).Scan(&allowed, &tokens)
+	if err != nil {
+		return ratelimit.Result{}, err
+	}
+	return ratelimit.NewResult(allowed, tokens, limit), nil
+}
+
+// PurgeRateLimits deletes buckets untouched since the given time. A bucket
+// idle longer than its refill period is full, the same as a missing one.
+func (s *RateLimitStore) PurgeRateLimits(ctx context.Context, before time.Time) (int, error) {
+	res, err := execContext(ctx, s.DB, `DELETE FROM rate_limits WHERE updated_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,386 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/VincentSamuelPaul/production-api/returns"
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+	"github.com/lib/pq"
+)
+
+func (s *PostgresStore) initReturns() error {
+	// returns are measured from delivery
+	query := `alter table orders add column if not exists delivered_at TIMESTAMP;`
+	_, err := s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	query = `create table if not exists returns (
+		id SERIAL PRIMARY KEY,
+		order_id INT NOT NULL REFERENCES orders(id),
+		user_id INT NOT NULL REFERENCES users(id),
+		status TEXT NOT NULL DEFAULT 'requested',
+		reason TEXT NOT NULL DEFAULT '',
+		decision_note TEXT NOT NULL DEFAULT '',
+		decided_by TEXT NOT NULL DEFAULT '',
+		refund_amount NUMERIC(13,3) NOT NULL DEFAULT 0,
+		created_at TIMESTAMP DEFAULT now(),
+		decided_at TIMESTAMP,
+		received_at TIMESTAMP,
+		refunded_at TIMESTAMP
+		);`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	query = `create table if not exists return_items (
+		id SERIAL PRIMARY KEY,
+		return_id INT NOT NULL REFERENCES returns(id) ON DELETE CASCADE,
+		order_item_id INT NOT NULL REFERENCES order_items(id),
+		quantity INT NOT NULL CHECK (quantity > 0),
+		reason TEXT NOT NULL DEFAULT '',
+		condition TEXT NOT NULL DEFAULT '',
+		refund_amount NUMERIC(13,3) NOT NULL
+		);`
+	_, err = s.DB.Exec(query)
+	return err
+}
+
+// RETURN FUNCTIONS
+
+// refunds are in the currency of the order
+const returnColumns = `id, order_id, user_id, status, reason, decision_note, decided_by, refund_amount,
+		created_at, decided_at, received_at, refunded_at,
+		(SELECT currency FROM orders WHERE orders.id = returns.order_id)`
+
+func scanReturn(row interface{ Scan(...any) error }, ret *structTypes.Return) error {
+	err := row.Scan(&ret.ID, &ret.OrderID, &ret.UserID, &ret.Status, &ret.Reason, &ret.DecisionNote,
+		&ret.DecidedBy, &ret.RefundAmount, &ret.CreatedAt, &ret.DecidedAt, &ret.ReceivedAt, &ret.RefundedAt, &ret.Currency)
+	inCurrency(ret.Currency, &ret.RefundAmount)
+	return err
+}
+
+// CreateReturn opens a return for items of the user's order. The order has
+// to be delivered after deliveredAfter, and no line can be returned more
+// times than it was bought, counting earlier returns that weren't rejected.
+// Each line's refund is fixed here from the price paid, plus its tax when
+// the tax was charged on top.
+func (s *PostgresStore) CreateReturn(ctx context.Context, userID, orderID int, req structTypes.ReturnRequest, deliveredAfter time.Time) (structTypes.Return, error) {
+	ctx, span := startMethodSpan(ctx, "CreateReturn")
+	defer span.End()
+	var ret structTypes.Return
+	if len(req.Items) == 0 {
+		return ret, fmt.Errorf("return has no items")
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return ret, err
+	}
+	defer tx.Rollback()
+
+	var owner int
+	var status string
+	var deliveredAt sql.NullTime
+	var subtotal, discount structTypes.Money
+	var taxIncluded bool
+	query := `SELECT user_id, status, delivered_at, COALESCE(subtotal, total + discount), discount, prices_include_tax, currency
+		FROM orders WHERE id = $1 FOR UPDATE`
+	err = queryRowContext(ctx, tx, query, orderID).Scan(&owner, &status, &deliveredAt, &subtotal, &discount, &taxIncluded, &ret.Currency)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && owner != userID) {
+		return ret, fmt.Errorf("%w: id %d", structTypes.ErrOrderNotFound, orderID)
+	}
+	if err != nil {
+		return ret, err
+	}
+	inCurrency(ret.Currency, &subtotal, &discount)
+	if !returns.Eligible(status) || !deliveredAt.Valid {
+		return ret, fmt.Errorf("%w: order %d is %s", structTypes.ErrReturnNotAllowed, orderID, status)
+	}
+	if deliveredAt.Time.Before(deliveredAfter) {
+		return ret, fmt.Errorf("%w: the return window for order %d has closed", structTypes.ErrReturnNotAllowed, orderID)
+	}
+
+	lineQuery := `
+		SELECT oi.product_id, oi.quantity, oi.price, oi.tax_amount,
+			COALESCE((
+				SELECT SUM(ri.quantity)
+				FROM return_items ri
+				JOIN returns r ON r.id = ri.return_id
+				WHERE ri.order_item_id = oi.id AND r.status <> $3
+			), 0)
+		FROM order_items oi
+		WHERE oi.id = $1 AND oi.order_id = $2`
+	requested := map[int]int{}
+	items := make([]structTypes.ReturnItem, 0, len(req.Items))
+	for _, line := range req.Items {
+		if line.Quantity <= 0 {
+			return ret, fmt.Errorf("invalid quantity %d for order_item_id %d", line.Quantity, line.OrderItemID)
+		}
+		item := structTypes.ReturnItem{OrderItemID: line.OrderItemID, Quantity: line.Quantity, Reason: line.Reason}
+		if item.Reason == "" {
+			item.Reason = req.Reason
+		}
+		var bought, returned int
+		var price, lineTax structTypes.Money
+		err := queryRowContext(ctx, tx, lineQuery, line.OrderItemID, orderID, structTypes.ReturnRejected).
+			Scan(&item.ProductID, &bought, &price, &lineTax, &returned)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ret, fmt.Errorf("%w: order_item_id %d is not part of order %d", structTypes.ErrReturnNotAllowed, line.OrderItemID, orderID)
+		}
+		if err != nil {
+			return ret, err
+		}
+		inCurrency(ret.Currency, &price, &lineTax)
+		requested[line.OrderItemID] += line.Quantity
+		if left := bought - returned; requested[line.OrderItemID] > left {
+			return ret, fmt.Errorf("%w: only %d of order_item_id %d can still be returned", structTypes.ErrReturnNotAllowed, left, line.OrderItemID)
+		}
+		item.RefundAmount = returns.LineRefund(price, line.Quantity, subtotal, discount)
+		if !taxIncluded {
+			item.RefundAmount = item.RefundAmount.Add(returns.TaxShare(lineTax, line.Quantity, bought))
+		}
+		ret.RefundAmount = ret.RefundAmount.Add(item.RefundAmount)
+		items = append(items, item)
+	}
+
+	query = `INSERT INTO returns (order_id, user_id, reason, refund_amount)
+		VALUES ($1, $2, $3, $4) RETURNING id`
+	if err := queryRowContext(ctx, tx, query, orderID, userID, req.Reason, ret.RefundAmount).Scan(&ret.ID); err != nil {
+		return ret, err
+	}
+	query = `INSERT INTO return_items (return_id, order_item_id, quantity, reason, refund_amount)
+		VALUES ($1, $2, $3, $4, $5)`
+	for _, item := range items {
+		if _, err := execContext(ctx, tx, query, ret.ID, item.OrderItemID, item.Quantity, item.Reason, item.RefundAmount); err != nil {
+			return ret, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return ret, err
+	}
+	return s.GetReturnByID(ctx, ret.ID)
+}
+
+// withReturnItems loads the items of every return in one query.
+func withReturnItems(ctx context.Context, db dbtx, list []structTypes.Return) ([]structTypes.Return, error) {
+	if len(list) == 0 {
+		return list, nil
+	}
+	ids := make([]int64, len(list))
+	index := map[int]int{}
+	for i := range list {
+		ids[i] = int64(list[i].ID)
+		index[list[i].ID] = i
+		list[i].Items = []structTypes.ReturnItem{}
+	}
+	query := `
+		SELECT ri.return_id, ri.id, ri.order_item_id, oi.product_id, ri.quantity, ri.reason, ri.condition, ri.refund_amount
+		FROM return_items ri
+		JOIN order_items oi ON oi.id = ri.order_item_id
+		WHERE ri.return_id = ANY($1)
+		ORDER BY ri.id`
+	rows, err := queryContext(ctx, db, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var returnID int
+		var item structTypes.ReturnItem
+		if err := rows.Scan(&returnID, &item.ID, &item.OrderItemID, &item.ProductID, &item.Quantity,
+			&item.Reason, &item.Condition, &item.RefundAmount); err != nil {
+			return nil, err
+		}
+		i := index[returnID]
+		inCurrency(list[i].Currency, &item.RefundAmount)
+		list[i].Items = append(list[i].Items, item)
+	}
+	return list, rows.Err()
+}
+
+func (s *PostgresStore) queryReturns(ctx context.Context, where string, args ...any) ([]structTypes.Return, error) {
+	query := `SELECT ` + returnColumns + ` FROM returns ` + where + ` ORDER BY created_at DESC, id DESC`
+	rows, err := queryContext(ctx, s.DB, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []structTypes.Return{}
+	for rows.Next() {
+		var ret structTypes.Return
+		if err := scanReturn(rows, &ret); err != nil {
+			return nil, err
+		}
+		list = append(list, ret)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return withReturnItems(ctx, s.DB, list)
+}
+
+func (s *PostgresStore) GetReturnsByOrderID(ctx context.Context, orderID int) ([]structTypes.Return, error) {
+	ctx, span := startMethodSpan(ctx, "GetReturnsByOrderID")
+	defer span.End()
+	return s.queryReturns(ctx, `WHERE order_id = $1`, orderID)
+}
+
+// GetReturns lists every return, or only those in status when it is set.
+func (s *PostgresStore) GetReturns(ctx context.Context, status string) ([]structTypes.Return, error) {
+	ctx, span := startMethodSpan(ctx, "GetReturns")
+	defer span.End()
+	if status == "" {
+		return s.queryReturns(ctx, ``)
+	}
+	return s.queryReturns(ctx, `WHERE status = $1`, status)
+}
+
+func (s *PostgresStore) GetReturnByID(ctx context.Context, returnID int) (structTypes.Return, error) {
+	ctx, span := startMethodSpan(ctx, "GetReturnByID")
+	defer span.End()
+	list, err := s.queryReturns(ctx, `WHERE id = $1`, returnID)
+	if err != nil {
+		return structTypes.Return{}, err
+	}
+	if len(list) == 0 {
+		return structTypes.Return{}, fmt.Errorf("%w: id %d", structTypes.ErrReturnNotFound, returnID)
+	}
+	return list[0], nil
+}
+
+// lockReturn loads the return for update and checks it can move to status.
+func lockReturn(ctx context.Context, tx dbtx, returnID int, status string) (structTypes.Return, error) {
+	var ret structTypes.Return
+	query := `SELECT ` + returnColumns + ` FROM returns WHERE id = $1 FOR UPDATE`
+	err := scanReturn(queryRowContext(ctx, tx, query, returnID), &ret)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ret, fmt.Errorf("%w: id %d", structTypes.ErrReturnNotFound, returnID)
+	}
+	if err != nil {
+		return ret, err
+	}
+	if !returns.Next(ret.Status, status) {
+		return ret, fmt.Errorf("%w: return %d is %s", structTypes.ErrReturnNotAllowed, returnID, ret.Status)
+	}
+	return ret, nil
+}
+
+// DecideReturn approves or rejects a requested return.
+func (s *PostgresStore) DecideReturn(ctx context.Context, returnID int, approve bool, note, actor string) (structTypes.Return, error) {
+	ctx, span := startMethodSpan(ctx, "DecideReturn")
+	defer span.End()
+	status := structTypes.ReturnRejected
+	if approve {
+		status = structTypes.ReturnApproved
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return structTypes.Return{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := lockReturn(ctx, tx, returnID, status); err != nil {
+		return structTypes.Return{}, err
+	}
+	query := `UPDATE returns SET status = $2, decision_note = $3, decided_by = $4, decided_at = now() WHERE id = $1`
+	if _, err := execContext(ctx, tx, query, returnID, status, note, actor); err != nil {
+		return structTypes.Return{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return structTypes.Return{}, err
+	}
+	return s.GetReturnByID(ctx, returnID)
+}
+
+// ReceiveReturn records the approved return as back in the warehouse.
+// conditions maps return item IDs to the condition they arrived in; items
+// left out are taken as resellable and put back in stock, damaged ones are
+// not.
+func (s *PostgresStore) ReceiveReturn(ctx context.Context, returnID int, conditions map[int]string) (structTypes.Return, error) {
+	ctx, span := startMethodSpan(ctx, "ReceiveReturn")
+	defer span.End()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return structTypes.Return{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := lockReturn(ctx, tx, returnID, structTypes.ReturnReceived); err != nil {
+		return structTypes.Return{}, err
+	}
+	list, err := withReturnItems(ctx, tx, []structTypes.Return{{ID: returnID}})
+	if err != nil {
+		return structTypes.Return{}, err
+	}
+	known := map[int]bool{}
+	for _, item := range list[0].Items {
+		known[item.ID] = true
+	}
+	for id := range conditions {
+		if !known[id] {
+			return structTypes.Return{}, fmt.Errorf("return item %d is not part of return %d", id, returnID)
+		}
+	}
+
+	for _, item := range list[0].Items {
+		condition := conditions[item.ID]
+		if condition == "" {
+			condition = structTypes.ConditionResellable
+		}
+		if condition != structTypes.ConditionResellable && condition != structTypes.ConditionDamaged {
+			return structTypes.Return{}, fmt.Errorf("invalid condition %q for return item %d", condition, item.ID)
+		}
+		query := `UPDATE return_items SET condition = $2 WHERE id = $1`
+		if _, err := execContext(ctx, tx, query, item.ID, condition); err != nil {
+			return structTypes.Return{}, err
+		}
+		if condition == structTypes.ConditionResellable {
+			query = `UPDATE products SET stock = stock + $2 WHERE id = $1`
+			if _, err := execContext(ctx, tx, query, item.ProductID, item.Quantity); err != nil {
+				return structTypes.Return{}, err
+			}
+		}
+	}
+
+	query := `UPDATE returns SET status = $2, received_at = now() WHERE id = $1`
+	if _, err := execContext(ctx, tx, query, returnID, structTypes.ReturnReceived); err != nil {
+		return structTypes.Return{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return structTypes.Return{}, err
+	}
+	return s.GetReturnByID(ctx, returnID)
+}
+
+// MarkReturnRefunded closes a received return with the amount actually
+// refunded to the customer.
+func (s *PostgresStore) MarkReturnRefunded(ctx context.Context, returnID int, amount structTypes.Money) (structTypes.Return, error) {
+	ctx, span := startMethodSpan(ctx, "MarkReturnRefunded")
+	defer span.End()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return structTypes.Return{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := lockReturn(ctx, tx, returnID, structTypes.ReturnRefunded); err != nil {
+		return structTypes.Return{}, err
+	}
+	query := `UPDATE returns SET status = $2, refund_amount = $3, refunded_at = now() WHERE id = $1`
+	if _, err := execContext(ctx, tx, query, returnID, structTypes.ReturnRefunded, amount); err != nil {
+		return structTypes.Return{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return structTypes.Return{}, err
+	}
+	return s.GetReturnByID(ctx, returnID)
+}
@@ -0,0 +1,457 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+	"github.com/lib/pq"
+)
+
+func (s *PostgresStore) initReviews() error {
+	query := `alter table reviews add column if not exists updated_at TIMESTAMP;`
+	_, err := s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	// one review per user and product: when the rule is introduced, older
+	// duplicates written before it are dropped in favour of the user's
+	// latest review. This runs once, with the index that enforces the rule.
+	query = `DO $$
+		BEGIN
+			IF to_regclass('reviews_user_product') IS NULL THEN
+				DELETE FROM reviews a USING reviews b
+					WHERE a.user_id = b.user_id AND a.product_id = b.product_id AND a.id < b.id;
+				CREATE UNIQUE INDEX reviews_user_product ON reviews (user_id, product_id);
+			END IF;
+		END $$;`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	// reviews written before moderation existed were already published
+	query = `alter table reviews
+		add column if not exists status TEXT NOT NULL DEFAULT 'approved',
+		add column if not exists moderation_note TEXT,
+		add column if not exists moderated_at TIMESTAMP;`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	query = `create table if not exists review_reports (
+		review_id INT NOT NULL REFERENCES reviews(id) ON DELETE CASCADE,
+		user_id INT NOT NULL REFERENCES users(id),
+		reason TEXT,
+		created_at TIMESTAMP NOT NULL DEFAULT now(),
+		PRIMARY KEY (review_id, user_id)
+		);`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	query = `create table if not exists product_ratings (
+		product_id INT PRIMARY KEY REFERENCES products(id) ON DELETE CASCADE,
+		review_count INT NOT NULL DEFAULT 0,
+		average NUMERIC(3,2) NOT NULL DEFAULT 0,
+		stars_1 INT NOT NULL DEFAULT 0,
+		stars_2 INT NOT NULL DEFAULT 0,
+		stars_3 INT NOT NULL DEFAULT 0,
+		stars_4 INT NOT NULL DEFAULT 0,
+		stars_5 INT NOT NULL DEFAULT 0,
+		updated_at TIMESTAMP NOT NULL DEFAULT now()
+		);`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	query = `alter table reviews
+		add column if not exists helpful_count INT NOT NULL DEFAULT 0,
+		add column if not exists unhelpful_count INT NOT NULL DEFAULT 0;`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	query = `create table if not exists review_votes (
+		review_id INT NOT NULL REFERENCES reviews(id) ON DELETE CASCADE,
+		user_id INT NOT NULL REFERENCES users(id),
+		helpful BOOLEAN NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT now(),
+		PRIMARY KEY (review_id, user_id)
+		);`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	query = `create index if not exists product_ratings_average on product_ratings (average);`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	// summarise products reviewed before ratings were kept; after that every
+	// review write refreshes its product's row
+	query = `INSERT INTO product_ratings (product_id, review_count, average, stars_1, stars_2, stars_3, stars_4, stars_5)
+		SELECT r.product_id, ` + ratingAggregates + `
+		FROM reviews r WHERE r.status = 'approved' AND r.product_id IS NOT NULL
+		GROUP BY r.product_id
+		ON CONFLICT (product_id) DO NOTHING;`
+	_, err = s.DB.Exec(query)
+	return err
+}
+
+const ratingAggregates = `count(*), COALESCE(round(avg(r.rating), 2), 0),
+			count(*) FILTER (WHERE r.rating = 1), count(*) FILTER (WHERE r.rating = 2),
+			count(*) FILTER (WHERE r.rating = 3), count(*) FILTER (WHERE r.rating = 4),
+			count(*) FILTER (WHERE r.rating = 5)`
+
+// refreshProductRating recomputes the product's rating summary from its
+// approved reviews. It runs in the transaction of every review write, so
+// the summary never drifts from the reviews.
+func refreshProductRating(ctx context.Context, db dbtx, productID int) error {
+	query := `INSERT INTO product_ratings (product_id, review_count, average, stars_1, stars_2, stars_3, stars_4, stars_5)
+		SELECT $1, ` + ratingAggregates + `
+		FROM reviews r WHERE r.product_id = $1 AND r.status = 'approved'
+		ON CONFLICT (product_id) DO UPDATE SET
+			review_count = EXCLUDED.review_count, average = EXCLUDED.average,
+			stars_1 = EXCLUDED.stars_1, stars_2 = EXCLUDED.stars_2, stars_3 = EXCLUDED.stars_3,
+			stars_4 = EXCLUDED.stars_4, stars_5 = EXCLUDED.stars_5, updated_at = now()`
+	_, err := execContext(ctx, db, query, productID)
+	return err
+}
+
+// verifiedPurchase is true for a review whose author has had the product
+// delivered on any order, including ones since returned.
+const verifiedPurchase = `EXISTS (
+			SELECT 1 FROM orders o JOIN order_items oi ON oi.order_id = o.id
+			WHERE o.user_id = r.user_id AND oi.product_id = r.product_id AND o.delivered_at IS NOT NULL)`
+
+const reviewColumns = `r.id, r.rating, COALESCE(r.comment, ''), r.created_at, r.updated_at, ` + verifiedPurchase + `,
+		r.helpful_count, r.unhelpful_count,
+		r.status, COALESCE(r.moderation_note, ''), r.moderated_at,
+		(SELECT count(*) FROM review_reports rr WHERE rr.review_id = r.id),
+		COALESCE(u.id, 0), COALESCE(u.username, ''), p.id, p.name`
+
+const reviewFrom = `FROM reviews r
+		JOIN products p ON p.id = r.product_id
+		LEFT JOIN users u ON u.id = r.user_id`
+
+func scanReview(row interface{ Scan(...any) error }, review *structTypes.ReviewResponse) error {
+	review.Product = &structTypes.Product{}
+	return row.Scan(
+		&review.ID,
+		&review.Rating,
+		&review.Comment,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+		&review.VerifiedPurchase,
+		&review.HelpfulCount,
+		&review.UnhelpfulCount,
+		&review.Status,
+		&review.ModerationNote,
+		&review.ModeratedAt,
+		&review.ReportCount,
+		&review.User.ID,
+		&review.User.Username,
+		&review.Product.ID,
+		&review.Product.Name,
+	)
+}
+
+// reviewSorts maps the ?sort= keys of a product's reviews to columns. Every
+// key sorts descending except lowest.
+var reviewSorts = map[string]string{
+	"":        "r.created_at",
+	"newest":  "r.created_at",
+	"highest": "r.rating",
+	"lowest":  "r.rating",
+	"helpful": "r.helpful_count",
+}
+
+// REVIEW FUNCTIONS
+
+// GetAllReviewsByProductID returns one page of the product's approved
+// reviews along with the product itself.
+func (s *PostgresStore) GetAllReviewsByProductID(ctx context.Context, q structTypes.ReviewQuery) (structTypes.ProductReviews, error) {
+	ctx, span := startMethodSpan(ctx, "GetAllReviewsByProductID")
+	defer span.End()
+
+	page := structTypes.ProductReviews{Sort: q.Sort, Page: q.Page, PageSize: q.PageSize, Reviews: []structTypes.ReviewResponse{}}
+	if page.Sort == "" {
+		page.Sort = "newest"
+	}
+	sort, err := sortColumn(q.Sort, reviewSorts)
+	if err != nil {
+		return page, err
+	}
+	page.Product, err = s.GetProductByID(ctx, q.ProductID)
+	if err != nil {
+		return page, err
+	}
+
+	query := `SELECT count(*) FROM reviews WHERE product_id = $1 AND status = $2`
+	if err := queryRowContext(ctx, s.DB, query, q.ProductID, structTypes.ReviewApproved).Scan(&page.Total); err != nil {
+		return page, err
+	}
+	query, args := newQuery("SELECT "+reviewColumns+" "+reviewFrom).
+		Where("r.product_id = ?", q.ProductID).
+		Where("r.status = ?", structTypes.ReviewApproved).
+		OrderBy(sort, q.Sort != "lowest").
+		OrderBy("r.id", true).
+		Limit(q.PageSize).
+		Offset((q.Page - 1) * q.PageSize).
+		Build()
+	rows, err := queryContext(ctx, s.DB, query, args...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var review structTypes.ReviewResponse
+		if err := scanReview(rows, &review); err != nil {
+			return page, err
+		}
+		// the product is on the page already and moderation details stay
+		// with moderators
+		review.Product = nil
+		review.Status, review.ModerationNote, review.ModeratedAt, review.ReportCount = "", "", nil, 0
+		page.Reviews = append(page.Reviews, review)
+	}
+	return page, rows.Err()
+}
+
+// UpdateReview edits the rating and comment of the user's review. Edited
+// reviews go through moderation again.
+func (s *PostgresStore) UpdateReview(ctx context.Context, review structTypes.ReviewRequest) error {
+	ctx, span := startMethodSpan(ctx, "UpdateReview")
+	defer span.End()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE reviews SET rating = $3, comment = $4, updated_at = now(),
+			status = $5, moderation_note = NULLIF($6, ''), moderated_at = NULL
+		WHERE user_id = $1 AND product_id = $2`
+	res, err := execContext(ctx, tx, query, review.UserID, review.ProductID, review.Rating, review.Comment, review.Status, review.ModerationNote)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%w: product_id %d", structTypes.ErrReviewNotFound, review.ProductID)
+	}
+	if err := refreshProductRating(ctx, tx, review.ProductID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) DeleteReview(ctx context.Context, userID, productID int) error {
+	ctx, span := startMethodSpan(ctx, "DeleteReview")
+	defer span.End()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := execContext(ctx, tx, `DELETE FROM reviews WHERE user_id = $1 AND product_id = $2`, userID, productID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%w: product_id %d", structTypes.ErrReviewNotFound, productID)
+	}
+	if err := refreshProductRating(ctx, tx, productID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetReviewsForModeration lists reviews in the given status, oldest first.
+// Without a status it returns the moderation queue: pending and flagged
+// reviews.
+func (s *PostgresStore) GetReviewsForModeration(ctx context.Context, status string) ([]structTypes.ReviewResponse, error) {
+	ctx, span := startMethodSpan(ctx, "GetReviewsForModeration")
+	defer span.End()
+
+	statuses := []string{status}
+	if status == "" {
+		statuses = []string{structTypes.ReviewPending, structTypes.ReviewFlagged}
+	}
+	query := `SELECT ` + reviewColumns + ` ` + reviewFrom + `
+		WHERE r.status = ANY($1) ORDER BY r.created_at, r.id`
+	rows, err := queryContext(ctx, s.DB, query, pq.Array(statuses))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []structTypes.ReviewResponse{}
+	for rows.Next() {
+		var review structTypes.ReviewResponse
+		if err := scanReview(rows, &review); err != nil {
+			return nil, err
+		}
+		list = append(list, review)
+	}
+	return list, rows.Err()
+}
+
+// ModerateReview approves or rejects a review, recording the moderator's
+// note. Approving clears the reports against it.
+func (s *PostgresStore) ModerateReview(ctx context.Context, reviewID int, approve bool, note string) (structTypes.ReviewResponse, error) {
+	ctx, span := startMethodSpan(ctx, "ModerateReview")
+	defer span.End()
+
+	var review structTypes.ReviewResponse
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return review, err
+	}
+	defer tx.Rollback()
+
+	status := structTypes.ReviewRejected
+	if approve {
+		status = structTypes.ReviewApproved
+	}
+	var productID int
+	query := `UPDATE reviews SET status = $2, moderation_note = NULLIF($3, ''), moderated_at = now()
+		WHERE id = $1 RETURNING product_id`
+	err = queryRowContext(ctx, tx, query, reviewID, status, note).Scan(&productID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return review, fmt.Errorf("%w: id %d", structTypes.ErrReviewNotFound, reviewID)
+	}
+	if err != nil {
+		return review, err
+	}
+	if err := refreshProductRating(ctx, tx, productID); err != nil {
+		return review, err
+	}
+	if approve {
+		if _, err := execContext(ctx, tx, `DELETE FROM review_reports WHERE review_id = $1`, reviewID); err != nil {
+			return review, err
+		}
+	}
+	query = `SELECT ` + reviewColumns + ` ` + reviewFrom + ` WHERE r.id = $1`
+	if err := scanReview(queryRowContext(ctx, tx, query, reviewID), &review); err != nil {
+		return review, err
+	}
+	return review, tx.Commit()
+}
+
+// ReportReview records a customer's report of a published review, once per
+// customer. When reports reach threshold the review is flagged, which takes
+// it down until a moderator looks at it.
+func (s *PostgresStore) ReportReview(ctx context.Context, reviewID, userID int, reason string, threshold int) error {
+	ctx, span := startMethodSpan(ctx, "ReportReview")
+	defer span.End()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status string
+	var author sql.NullInt64
+	var productID int
+	query := `SELECT status, user_id, product_id FROM reviews WHERE id = $1 FOR UPDATE`
+	err = queryRowContext(ctx, tx, query, reviewID).Scan(&status, &author, &productID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && status != structTypes.ReviewApproved) {
+		return fmt.Errorf("%w: id %d", structTypes.ErrReviewNotFound, reviewID)
+	}
+	if err != nil {
+		return err
+	}
+	if author.Valid && int(author.Int64) == userID {
+		return fmt.Errorf("%w: can't report your own review", structTypes.ErrConflict)
+	}
+	query = `INSERT INTO review_reports (review_id, user_id, reason) VALUES ($1, $2, NULLIF($3, ''))
+		ON CONFLICT (review_id, user_id) DO NOTHING`
+	if _, err := execContext(ctx, tx, query, reviewID, userID, reason); err != nil {
+		return err
+	}
+	var reports int
+	if err := queryRowContext(ctx, tx, `SELECT count(*) FROM review_reports WHERE review_id = $1`, reviewID).Scan(&reports); err != nil {
+		return err
+	}
+	if threshold > 0 && reports >= threshold {
+		query := `UPDATE reviews SET status = $2, moderation_note = $3 WHERE id = $1`
+		note := fmt.Sprintf("reported by %d customers", reports)
+		if _, err := execContext(ctx, tx, query, reviewID, structTypes.ReviewFlagged, note); err != nil {
+			return err
+		}
+		if err := refreshProductRating(ctx, tx, productID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// VoteReview records whether the user found a published review helpful.
+// Each user has one vote per review; voting again replaces it.
+func (s *PostgresStore) VoteReview(ctx context.Context, reviewID, userID int, helpful bool) (structTypes.ReviewResponse, error) {
+	ctx, span := startMethodSpan(ctx, "VoteReview")
+	defer span.End()
+	return s.changeReviewVote(ctx, reviewID, userID, func(tx dbtx) error {
+		query := `INSERT INTO review_votes (review_id, user_id, helpful) VALUES ($1, $2, $3)
+			ON CONFLICT (review_id, user_id) DO UPDATE SET helpful = EXCLUDED.helpful, created_at = now()`
+		_, err := execContext(ctx, tx, query, reviewID, userID, helpful)
+		return err
+	})
+}
+
+func (s *PostgresStore) RemoveReviewVote(ctx context.Context, reviewID, userID int) (structTypes.ReviewResponse, error) {
+	ctx, span := startMethodSpan(ctx, "RemoveReviewVote")
+	defer span.End()
+	return s.changeReviewVote(ctx, reviewID, userID, func(tx dbtx) error {
+		_, err := execContext(ctx, tx, `DELETE FROM review_votes WHERE review_id = $1 AND user_id = $2`, reviewID, userID)
+		return err
+	})
+}
+
+// changeReviewVote locks the review, applies change to the user's vote and
+// recounts the review's votes.
+func (s *PostgresStore) changeReviewVote(ctx context.Context, reviewID, userID int, change func(tx dbtx) error) (structTypes.ReviewResponse, error) {
+	var review structTypes.ReviewResponse
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return review, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	var author sql.NullInt64
+	err = queryRowContext(ctx, tx, `SELECT status, user_id FROM reviews WHERE id = $1 FOR UPDATE`, reviewID).Scan(&status, &author)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && status != structTypes.ReviewApproved) {
+		return review, fmt.Errorf("%w: id %d", structTypes.ErrReviewNotFound, reviewID)
+	}
+	if err != nil {
+		return review, err
+	}
+	if author.Valid && int(author.Int64) == userID {
+		return review, fmt.Errorf("%w: can't vote on your own review", structTypes.ErrConflict)
+	}
+	if err := change(tx); err != nil {
+		return review, err
+	}
+	query := `UPDATE reviews SET
+			helpful_count = (SELECT count(*) FROM review_votes WHERE review_id = $1 AND helpful),
+			unhelpful_count = (SELECT count(*) FROM review_votes WHERE review_id = $1 AND NOT helpful)
+		WHERE id = $1`
+	if _, err := execContext(ctx, tx, query, reviewID); err != nil {
+		return review, err
+	}
+	query = `SELECT ` + reviewColumns + ` ` + reviewFrom + ` WHERE r.id = $1`
+	if err := scanReview(queryRowContext(ctx, tx, query, reviewID), &review); err != nil {
+		return review, err
+	}
+	review.Status, review.ModerationNote, review.ModeratedAt, review.ReportCount = "", "", nil, 0
+	return review, tx.Commit()
+}
@@ -0,0 +1,268 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/VincentSamuelPaul/production-api/shipping"
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+func (s *PostgresStore) initShipping() error {
+	query := `alter table products add column if not exists weight_grams INT NOT NULL DEFAULT 0;`
+	_, err := s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	query = `create table if not exists shipping_methods (
+		id SERIAL PRIMARY KEY,
+		code TEXT UNIQUE NOT NULL,
+		name TEXT NOT NULL,
+		estimated_days INT NOT NULL DEFAULT 0,
+		free_over NUMERIC(13,3) NOT NULL DEFAULT 0,
+		rates JSONB NOT NULL DEFAULT '[]',
+		active BOOLEAN NOT NULL DEFAULT true,
+		created_at TIMESTAMP DEFAULT now()
+		);`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	query = `alter table orders
+		add column if not exists shipping_method TEXT,
+		add column if not exists shipping_cost NUMERIC(13,3) NOT NULL DEFAULT 0;`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	query = `create table if not exists shipments (
+		id SERIAL PRIMARY KEY,
+		order_id INT NOT NULL REFERENCES orders(id),
+		carrier TEXT NOT NULL,
+		tracking_number TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'shipped',
+		shipped_at TIMESTAMP NOT NULL DEFAULT now(),
+		delivered_at TIMESTAMP
+		);`
+	_, err = s.DB.Exec(query)
+	return err
+}
+
+// SHIPPING FUNCTIONS
+
+const shippingMethodColumns = `id, code, name, estimated_days, free_over, rates, active, created_at`
+
+// scanShippingMethod reads a method whose rates are in the base currency c.
+func scanShippingMethod(row interface{ Scan(...any) error }, method *structTypes.ShippingMethod, c structTypes.Currency) error {
+	var rates []byte
+	if err := row.Scan(&method.ID, &method.Code, &method.Name, &method.EstimatedDays, &method.FreeOver,
+		&rates, &method.Active, &method.CreatedAt); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(rates, &method.Rates); err != nil {
+		return err
+	}
+	inCurrency(c, &method.FreeOver)
+	for i := range method.Rates {
+		inCurrency(c, &method.Rates[i].Base, &method.Rates[i].PerKg)
+	}
+	return nil
+}
+
+func (s *PostgresStore) CreateShippingMethod(ctx context.Context, method structTypes.ShippingMethod) (structTypes.ShippingMethod, error) {
+	ctx, span := startMethodSpan(ctx, "CreateShippingMethod")
+	defer span.End()
+
+	rates, err := json.Marshal(method.Rates)
+	if err != nil {
+		return method, err
+	}
+	query := `INSERT INTO shipping_methods (code, name, estimated_days, free_over, rates, active)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (code) DO NOTHING
+		RETURNING ` + shippingMethodColumns
+	err = scanShippingMethod(queryRowContext(ctx, s.DB, query, method.Code, method.Name, method.EstimatedDays,
+		method.FreeOver, rates, method.Active), &method, s.base())
+	if errors.Is(err, sql.ErrNoRows) {
+		return method, fmt.Errorf("%w: shipping method %s already exists", structTypes.ErrConflict, method.Code)
+	}
+	return method, err
+}
+
+func shippingMethods(ctx context.Context, db dbtx, activeOnly bool, base structTypes.Currency) ([]structTypes.ShippingMethod, error) {
+	query := `SELECT ` + shippingMethodColumns + ` FROM shipping_methods`
+	if activeOnly {
+		query += ` WHERE active`
+	}
+	rows, err := queryContext(ctx, db, query+` ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []structTypes.ShippingMethod{}
+	for rows.Next() {
+		var method structTypes.ShippingMethod
+		if err := scanShippingMethod(rows, &method, base); err != nil {
+			return nil, err
+		}
+		list = append(list, method)
+	}
+	return list, rows.Err()
+}
+
+func (s *PostgresStore) GetShippingMethods(ctx context.Context, activeOnly bool) ([]structTypes.ShippingMethod, error) {
+	ctx, span := startMethodSpan(ctx, "GetShippingMethods")
+	defer span.End()
+	return shippingMethods(ctx, s.DB, activeOnly, s.base())
+}
+
+func (s *PostgresStore) SetShippingMethodActive(ctx context.Context, methodID int, active bool) error {
+	ctx, span := startMethodSpan(ctx, "SetShippingMethodActive")
+	defer span.End()
+	res, err := execContext(ctx, s.DB, `UPDATE shipping_methods SET active = $2 WHERE id = $1`, methodID, active)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%w: id %d", structTypes.ErrShippingMethodNotFound, methodID)
+	}
+	return nil
+}
+
+const shipmentColumns = `id, order_id, carrier, tracking_number, status, shipped_at, delivered_at`
+
+func scanShipment(row interface{ Scan(...any) error }, shipment *structTypes.Shipment) error {
+	return row.Scan(&shipment.ID, &shipment.OrderID, &shipment.Carrier, &shipment.TrackingNumber,
+		&shipment.Status, &shipment.ShippedAt, &shipment.DeliveredAt)
+}
+
+// CreateShipment records a parcel handed to the carrier and marks the order
+// shipped. Only paid orders can ship; more parcels can be added to an order
+// that is already shipped.
+func (s *PostgresStore) CreateShipment(ctx context.Context, orderID int, carrier, trackingNumber string) (structTypes.Shipment, error) {
+	ctx, span := startMethodSpan(ctx, "CreateShipment")
+	defer span.End()
+	var shipment structTypes.Shipment
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return shipment, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = queryRowContext(ctx, tx, `SELECT status FROM orders WHERE id = $1 FOR UPDATE`, orderID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return shipment, fmt.Errorf("%w: id %d", structTypes.ErrOrderNotFound, orderID)
+	}
+	if err != nil {
+		return shipment, err
+	}
+	if status != structTypes.OrderPaid && status != structTypes.OrderShipped {
+		return shipment, fmt.Errorf("%w: order %d is %s", structTypes.ErrOrderNotShippable, orderID, status)
+	}
+
+	query := `INSERT INTO shipments (order_id, carrier, tracking_number)
+		VALUES ($1, $2, $3) RETURNING ` + shipmentColumns
+	if err := scanShipment(queryRowContext(ctx, tx, query, orderID, carrier, trackingNumber), &shipment); err != nil {
+		return shipment, err
+	}
+	query = `UPDATE orders SET status = $2 WHERE id = $1`
+	if _, err := execContext(ctx, tx, query, orderID, structTypes.OrderShipped); err != nil {
+		return shipment, err
+	}
+	return shipment, tx.Commit()
+}
+
+// MarkShipmentDelivered records the delivery of a parcel. The order becomes
+// delivered, which opens its return window, once none of its parcels are
+// still on the way.
+func (s *PostgresStore) MarkShipmentDelivered(ctx context.Context, shipmentID int) (structTypes.Shipment, error) {
+	ctx, span := startMethodSpan(ctx, "MarkShipmentDelivered")
+	defer span.End()
+	var shipment structTypes.Shipment
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return shipment, err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE shipments SET status = $2, delivered_at = COALESCE(delivered_at, now())
+		WHERE id = $1 RETURNING ` + shipmentColumns
+	err = scanShipment(queryRowContext(ctx, tx, query, shipmentID, structTypes.ShipmentDelivered), &shipment)
+	if errors.Is(err, sql.ErrNoRows) {
+		return shipment, fmt.Errorf("%w: id %d", structTypes.ErrShipmentNotFound, shipmentID)
+	}
+	if err != nil {
+		return shipment, err
+	}
+
+	query = `
+		UPDATE orders o
+		SET status = $2, delivered_at = COALESCE(o.delivered_at, now())
+		WHERE o.id = $1 AND o.status = $3
+			AND NOT EXISTS (SELECT 1 FROM shipments sh WHERE sh.order_id = o.id AND sh.status <> $4)`
+	_, err = execContext(ctx, tx, query, shipment.OrderID, structTypes.OrderDelivered, structTypes.OrderShipped, structTypes.ShipmentDelivered)
+	if err != nil {
+		return shipment, err
+	}
+	return shipment, tx.Commit()
+}
+
+func orderShipments(ctx context.Context, db dbtx, orderID int) ([]structTypes.Shipment, error) {
+	query := `SELECT ` + shipmentColumns + ` FROM shipments WHERE order_id = $1 ORDER BY id`
+	rows, err := queryContext(ctx, db, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []structTypes.Shipment
+	for rows.Next() {
+		var shipment structTypes.Shipment
+		if err := scanShipment(rows, &shipment); err != nil {
+			return nil, err
+		}
+		list = append(list, shipment)
+	}
+	return list, rows.Err()
+}
+
+// orderShipping prices shipping for an order placed with the given method,
+// or with the cheapest method that can ship it when code is empty. Orders
+// ship for free while no shipping methods are set up. Rates, value and the
+// cost are in the base currency.
+func orderShipping(ctx context.Context, tx dbtx, code, country string, weightGrams int, base structTypes.Currency, value structTypes.Money) (string, structTypes.Money, error) {
+	methods, err := shippingMethods(ctx, tx, true, base)
+	if err != nil {
+		return "", structTypes.Money{}, err
+	}
+	if code == "" {
+		if len(methods) == 0 {
+			return "", structTypes.Money{}, nil
+		}
+		quotes := shipping.Quotes(methods, country, weightGrams, value)
+		if len(quotes) == 0 {
+			return "", structTypes.Money{}, fmt.Errorf("%w to %s", structTypes.ErrShippingUnavailable, country)
+		}
+		return quotes[0].Method, quotes[0].Cost, nil
+	}
+	for _, method := range methods {
+		if method.Code != code {
+			continue
+		}
+		cost, ok := shipping.Quote(method, country, weightGrams, value)
+		if !ok {
+			return "", structTypes.Money{}, fmt.Errorf("%w: %s doesn't ship to %s", structTypes.ErrShippingUnavailable, code, country)
+		}
+		return code, cost, nil
+	}
+	return "", structTypes.Money{}, fmt.Errorf("%w: %s", structTypes.ErrShippingMethodNotFound, code)
+}
@@ -0,0 +1,120 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/VincentSamuelPaul/production-api/tax"
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+func (s *PostgresStore) initTax() error {
+	query := `alter table products add column if not exists tax_class TEXT NOT NULL DEFAULT '';`
+	_, err := s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	query = `create table if not exists tax_rates (
+		id SERIAL PRIMARY KEY,
+		country CHAR(2) NOT NULL,
+		region TEXT NOT NULL DEFAULT '',
+		tax_class TEXT NOT NULL DEFAULT '',
+		rate_bps INT NOT NULL CHECK (rate_bps >= 0),
+		created_at TIMESTAMP DEFAULT now(),
+		UNIQUE (country, region, tax_class)
+		);`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	query = `alter table order_items
+		add column if not exists tax_amount NUMERIC(13,3) NOT NULL DEFAULT 0,
+		add column if not exists tax_rate_bps INT NOT NULL DEFAULT 0;`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	query = `alter table orders
+		add column if not exists tax_total NUMERIC(13,3) NOT NULL DEFAULT 0,
+		add column if not exists prices_include_tax BOOLEAN NOT NULL DEFAULT false;`
+	_, err = s.DB.Exec(query)
+	return err
+}
+
+// UseTax sets the calculator orders are taxed with. inclusive tells whether
+// product prices already contain tax. Without a calculator orders are not
+// taxed.
+func (s *PostgresStore) UseTax(calculator tax.Calculator, inclusive bool) {
+	s.tax = calculator
+	s.taxInclusive = inclusive
+}
+
+func (s *PostgresStore) calculateTax(ctx context.Context, req tax.Request) (tax.Result, error) {
+	req.Inclusive = s.taxInclusive
+	if s.tax == nil {
+		return tax.None{}.Calculate(ctx, req)
+	}
+	result, err := s.tax.Calculate(ctx, req)
+	if err != nil {
+		return result, err
+	}
+	if len(result.Lines) != len(req.Lines) {
+		return result, fmt.Errorf("tax calculator returned %d lines for %d", len(result.Lines), len(req.Lines))
+	}
+	return result, nil
+}
+
+// TAX FUNCTIONS
+
+func (s *PostgresStore) GetTaxRates(ctx context.Context) ([]structTypes.TaxRate, error) {
+	ctx, span := startMethodSpan(ctx, "GetTaxRates")
+	defer span.End()
+
+	query := `SELECT id, country, region, tax_class, rate_bps, created_at
+		FROM tax_rates ORDER BY country, region, tax_class`
+	rows, err := queryContext(ctx, s.DB, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []structTypes.TaxRate{}
+	for rows.Next() {
+		var rate structTypes.TaxRate
+		if err := rows.Scan(&rate.ID, &rate.Country, &rate.Region, &rate.TaxClass, &rate.RateBasisPoints, &rate.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, rate)
+	}
+	return list, rows.Err()
+}
+
+// PutTaxRate creates the rate for its jurisdiction and class, or replaces
+// the one already there.
+func (s *PostgresStore) PutTaxRate(ctx context.Context, rate structTypes.TaxRate) (structTypes.TaxRate, error) {
+	ctx, span := startMethodSpan(ctx, "PutTaxRate")
+	defer span.End()
+
+	query := `INSERT INTO tax_rates (country, region, tax_class, rate_bps)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (country, region, tax_class) DO UPDATE SET rate_bps = EXCLUDED.rate_bps
+		RETURNING id, created_at`
+	err := queryRowContext(ctx, s.DB, query, rate.Country, rate.Region, rate.TaxClass, rate.RateBasisPoints).
+		Scan(&rate.ID, &rate.CreatedAt)
+	return rate, err
+}
+
+func (s *PostgresStore) DeleteTaxRate(ctx context.Context, rateID int) error {
+	ctx, span := startMethodSpan(ctx, "DeleteTaxRate")
+	defer span.End()
+	res, err := execContext(ctx, s.DB, `DELETE FROM tax_rates WHERE id = $1`, rateID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%w: id %d", structTypes.ErrTaxRateNotFound, rateID)
+	}
+	return nil
+}
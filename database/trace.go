@@ -0,0 +1,55 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/VincentSamuelPaul/production-api/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// dbtx is satisfied by both *sql.DB and *sql.Tx so statements can be traced
+// the same way inside and outside of transactions.
+type dbtx interface {
+	ExecContext(context.Context, string, ...any) (sql.Result, error)
+	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+	QueryRowContext(context.Context, string, ...any) *sql.Row
+}
+
+func startMethodSpan(ctx context.Context, method string) (context.Context, trace.Span) {
+	return telemetry.StartSpan(ctx, "PostgresStore."+method,
+		attribute.String("db.system", "postgresql"),
+	)
+}
+
+func startQuerySpan(ctx context.Context, op, query string) (context.Context, trace.Span) {
+	return telemetry.StartSpan(ctx, "sql."+op,
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.statement", query),
+	)
+}
+
+func execContext(ctx context.Context, db dbtx, query string, args ...any) (sql.Result, error) {
+	ctx, span := startQuerySpan(ctx, "exec", query)
+	defer span.End()
+	res, err := db.ExecContext(ctx, query, args...)
+	telemetry.RecordError(span, err)
+	return res, err
+}
+
+func queryContext(ctx context.Context, db dbtx, query string, args ...any) (*sql.Rows, error) {
+	ctx, span := startQuerySpan(ctx, "query", query)
+	defer span.End()
+	rows, err := db.QueryContext(ctx, query, args...)
+	telemetry.RecordError(span, err)
+	return rows, err
+}
+
+func queryRowContext(ctx context.Context, db dbtx, query string, args ...any) *sql.Row {
+	ctx, span := startQuerySpan(ctx, "query_row", query)
+	defer span.End()
+	row := db.QueryRowContext(ctx, query, args...)
+	telemetry.RecordError(span, row.Err())
+	return row
+}
@@ -0,0 +1,389 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+const saveForLaterName = "Saved for later"
+
+func (s *PostgresStore) initWishlists() error {
+	query := `create table if not exists wishlists (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id),
+		name TEXT NOT NULL,
+		is_public BOOLEAN NOT NULL DEFAULT false,
+		share_token TEXT UNIQUE,
+		save_for_later BOOLEAN NOT NULL DEFAULT false,
+		created_at TIMESTAMP DEFAULT now()
+		);`
+	_, err := s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	// every user has at most one list that "save for later" puts items in
+	query = `create unique index if not exists wishlists_save_for_later_idx
+		on wishlists (user_id) where save_for_later;`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	query = `create table if not exists wishlist_items (
+		id SERIAL PRIMARY KEY,
+		wishlist_id INT NOT NULL REFERENCES wishlists(id) ON DELETE CASCADE,
+		product_id INT NOT NULL REFERENCES products(id),
+		quantity INT NOT NULL DEFAULT 1 CHECK (quantity > 0),
+		added_at TIMESTAMP DEFAULT now(),
+		UNIQUE (wishlist_id, product_id)
+		);`
+	_, err = s.DB.Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func newShareToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+const wishlistColumns = "w.id, w.user_id, w.name, w.is_public, COALESCE(w.share_token, ''), w.save_for_later, w.created_at"
+
+func scanWishlist(row interface{ Scan(...any) error }, list *structTypes.Wishlist) error {
+	return row.Scan(
+		&list.ID,
+		&list.UserID,
+		&list.Name,
+		&list.Public,
+		&list.ShareToken,
+		&list.SaveForLater,
+		&list.CreatedAt,
+	)
+}
+
+func (s *PostgresStore) GetWishlistsByUserID(ctx context.Context, userID int) ([]structTypes.Wishlist, error) {
+	ctx, span := startMethodSpan(ctx, "GetWishlistsByUserID")
+	defer span.End()
+	if err := userExists(ctx, s.DB, userID); err != nil {
+		return nil, err
+	}
+	query := `SELECT ` + wishlistColumns + ` FROM wishlists w WHERE w.user_id = $1 ORDER BY w.id`
+	rows, err := queryContext(ctx, s.DB, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	lists := []structTypes.Wishlist{}
+	for rows.Next() {
+		var list structTypes.Wishlist
+		if err := scanWishlist(rows, &list); err != nil {
+			return nil, err
+		}
+		lists = append(lists, list)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return lists, nil
+}
+
+func (s *PostgresStore) CreateWishlist(ctx context.Context, userID int, name string, public bool) (structTypes.Wishlist, error) {
+	ctx, span := startMethodSpan(ctx, "CreateWishlist")
+	defer span.End()
+	var list structTypes.Wishlist
+	if err := userExists(ctx, s.DB, userID); err != nil {
+		return list, err
+	}
+	var token sql.NullString
+	if public {
+		t, err := newShareToken()
+		if err != nil {
+			return list, err
+		}
+		token = sql.NullString{String: t, Valid: true}
+	}
+	query := `INSERT INTO wishlists AS w (user_id, name, is_public, share_token)
+		VALUES ($1, $2, $3, $4) RETURNING ` + wishlistColumns
+	err := scanWishlist(queryRowContext(ctx, s.DB, query, userID, name, public, token), &list)
+	list.Items = []structTypes.WishlistItem{}
+	return list, err
+}
+
+// UpdateWishlist renames the list and changes its visibility. Making a list
+// public issues a share token; making it private again revokes it.
+func (s *PostgresStore) UpdateWishlist(ctx context.Context, userID, wishlistID int, name string, public bool) (structTypes.Wishlist, error) {
+	ctx, span := startMethodSpan(ctx, "UpdateWishlist")
+	defer span.End()
+	var list structTypes.Wishlist
+	token, err := newShareToken()
+	if err != nil {
+		return list, err
+	}
+	query := `
+		UPDATE wishlists AS w SET
+			name = $3,
+			is_public = $4,
+			share_token = CASE WHEN NOT $4 THEN NULL ELSE COALESCE(w.share_token, $5) END
+		WHERE w.id = $1 AND w.user_id = $2
+		RETURNING ` + wishlistColumns
+	err = scanWishlist(queryRowContext(ctx, s.DB, query, wishlistID, userID, name, public, token), &list)
+	if errors.Is(err, sql.ErrNoRows) {
+		return list, fmt.Errorf("%w: id %d", structTypes.ErrWishlistNotFound, wishlistID)
+	}
+	if err != nil {
+		return list, err
+	}
+	return s.withWishlistItems(ctx, list)
+}
+
+func (s *PostgresStore) DeleteWishlist(ctx context.Context, userID, wishlistID int) error {
+	ctx, span := startMethodSpan(ctx, "DeleteWishlist")
+	defer span.End()
+	res, err := execContext(ctx, s.DB, `DELETE FROM wishlists WHERE id = $1 AND user_id = $2`, wishlistID, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%w: id %d", structTypes.ErrWishlistNotFound, wishlistID)
+	}
+	return nil
+}
+
+// GetWishlist returns a list of the user with its items. Lists of other
+// users are reported as missing.
+func (s *PostgresStore) GetWishlist(ctx context.Context, userID, wishlistID int) (structTypes.Wishlist, error) {
+	ctx, span := startMethodSpan(ctx, "GetWishlist")
+	defer span.End()
+	var list structTypes.Wishlist
+	query := `SELECT ` + wishlistColumns + ` FROM wishlists w WHERE w.id = $1 AND w.user_id = $2`
+	err := scanWishlist(queryRowContext(ctx, s.DB, query, wishlistID, userID), &list)
+	if errors.Is(err, sql.ErrNoRows) {
+		return list, fmt.Errorf("%w: id %d", structTypes.ErrWishlistNotFound, wishlistID)
+	}
+	if err != nil {
+		return list, err
+	}
+	return s.withWishlistItems(ctx, list)
+}
+
+// GetSharedWishlist returns a public list by its share token.
+func (s *PostgresStore) GetSharedWishlist(ctx context.Context, token string) (structTypes.Wishlist, error) {
+	ctx, span := startMethodSpan(ctx, "GetSharedWishlist")
+	defer span.End()
+	var list structTypes.Wishlist
+	query := `SELECT ` + wishlistColumns + ` FROM wishlists w WHERE w.share_token = $1 AND w.is_public`
+	err := scanWishlist(queryRowContext(ctx, s.DB, query, token), &list)
+	if errors.Is(err, sql.ErrNoRows) {
+		return list, structTypes.ErrWishlistNotFound
+	}
+	if err != nil {
+		return list, err
+	}
+	return s.withWishlistItems(ctx, list)
+}
+
+func (s *PostgresStore) withWishlistItems(ctx context.Context, list structTypes.Wishlist) (structTypes.Wishlist, error) {
+	query := `
+		SELECT wi.id, p.id, p.name, p.description, p.price, wi.quantity, p.stock > 0 AND NOT p.discontinued, wi.added_at
+		FROM wishlist_items wi
+		JOIN products p ON p.id = wi.product_id
+		WHERE wi.wishlist_id = $1
+		ORDER BY wi.added_at, wi.id
+	`
+	rows, err := queryContext(ctx, s.DB, query, list.ID)
+	if err != nil {
+		return list, err
+	}
+	defer rows.Close()
+	list.Items = []structTypes.WishlistItem{}
+	for rows.Next() {
+		var item structTypes.WishlistItem
+		if err := rows.Scan(
+			&item.ID,
+			&item.ProductID,
+			&item.ProductName,
+			&item.ProductDescription,
+			&item.Price,
+			&item.Quantity,
+			&item.InStock,
+			&item.AddedAt,
+		); err != nil {
+			return list, err
+		}
+		inCurrency(s.base(), &item.Price)
+		list.Items = append(list.Items, item)
+	}
+	return list, rows.Err()
+}
+
+// ownedWishlist locks the list for update if it belongs to the user.
+func ownedWishlist(ctx context.Context, tx dbtx, userID, wishlistID int) error {
+	var id int
+	err := queryRowContext(ctx, tx, `SELECT id FROM wishlists WHERE id = $1 AND user_id = $2 FOR UPDATE`, wishlistID, userID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: id %d", structTypes.ErrWishlistNotFound, wishlistID)
+	}
+	return err
+}
+
+func putWishlistItem(ctx context.Context, tx dbtx, wishlistID, productID, quantity int) error {
+	query := `
+		INSERT INTO wishlist_items (wishlist_id, product_id, quantity)
+		SELECT $1, p.id, $3 FROM products p WHERE p.id = $2
+		ON CONFLICT (wishlist_id, product_id)
+		DO UPDATE SET quantity = EXCLUDED.quantity;
+	`
+	res, err := execContext(ctx, tx, query, wishlistID, productID, quantity)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%w: id %d", structTypes.ErrProductNotFound, productID)
+	}
+	return nil
+}
+
+func (s *PostgresStore) AddToWishlist(ctx context.Context, userID, wishlistID, productID, quantity int) error {
+	ctx, span := startMethodSpan(ctx, "AddToWishlist")
+	defer span.End()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := ownedWishlist(ctx, tx, userID, wishlistID); err != nil {
+		return err
+	}
+	if err := putWishlistItem(ctx, tx, wishlistID, productID, quantity); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) RemoveFromWishlist(ctx context.Context, userID, wishlistID, productID int) error {
+	ctx, span := startMethodSpan(ctx, "RemoveFromWishlist")
+	defer span.End()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := ownedWishlist(ctx, tx, userID, wishlistID); err != nil {
+		return err
+	}
+	if err := deleteWishlistItem(ctx, tx, wishlistID, productID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func deleteWishlistItem(ctx context.Context, tx dbtx, wishlistID, productID int) error {
+	res, err := execContext(ctx, tx, `DELETE FROM wishlist_items WHERE wishlist_id = $1 AND product_id = $2`, wishlistID, productID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%w: product %d", structTypes.ErrWishlistItemNotFound, productID)
+	}
+	return nil
+}
+
+// MoveWishlistItemToCart adds the wishlist item to the user's cart, subject
+// to the usual stock checks, and removes it from the list. It returns the
+// resulting cart quantity.
+func (s *PostgresStore) MoveWishlistItemToCart(ctx context.Context, userID, wishlistID, productID int) (int, error) {
+	ctx, span := startMethodSpan(ctx, "MoveWishlistItemToCart")
+	defer span.End()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if err := ownedWishlist(ctx, tx, userID, wishlistID); err != nil {
+		return 0, err
+	}
+	var quantity int
+	err = queryRowContext(ctx, tx, `SELECT quantity FROM wishlist_items WHERE wishlist_id = $1 AND product_id = $2`, wishlistID, productID).Scan(&quantity)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%w: product %d", structTypes.ErrWishlistItemNotFound, productID)
+	}
+	if err != nil {
+		return 0, err
+	}
+	cartID, err := cartIDForUser(ctx, tx, userID)
+	if err != nil {
+		return 0, err
+	}
+	cartQuantity, err := putCartItem(ctx, tx, cartID, productID, func(current int) int {
+		return current + quantity
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := deleteWishlistItem(ctx, tx, wishlistID, productID); err != nil {
+		return 0, err
+	}
+	return cartQuantity, tx.Commit()
+}
+
+// SaveForLater moves a cart item to the given wishlist, or to the user's
+// "Saved for later" list (created on first use) when wishlistID is 0.
+func (s *PostgresStore) SaveForLater(ctx context.Context, userID, productID, wishlistID int) (int, error) {
+	ctx, span := startMethodSpan(ctx, "SaveForLater")
+	defer span.End()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	cartID, err := cartIDForUser(ctx, tx, userID)
+	if err != nil {
+		return 0, err
+	}
+	if wishlistID == 0 {
+		query := `
+			INSERT INTO wishlists (user_id, name, save_for_later) VALUES ($1, $2, true)
+			ON CONFLICT (user_id) WHERE save_for_later DO UPDATE SET name = wishlists.name
+			RETURNING id;
+		`
+		if err := queryRowContext(ctx, tx, query, userID, saveForLaterName).Scan(&wishlistID); err != nil {
+			return 0, err
+		}
+	} else if err := ownedWishlist(ctx, tx, userID, wishlistID); err != nil {
+		return 0, err
+	}
+
+	var quantity int
+	query := `DELETE FROM cart_items WHERE cart_id = $1 AND product_id = $2 RETURNING quantity`
+	err = queryRowContext(ctx, tx, query, cartID, productID).Scan(&quantity)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%w: product %d", structTypes.ErrCartItemNotFound, productID)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := putWishlistItem(ctx, tx, wishlistID, productID, quantity); err != nil {
+		return 0, err
+	}
+	if err := touchCart(ctx, tx, cartID); err != nil {
+		return 0, err
+	}
+	return wishlistID, tx.Commit()
+}
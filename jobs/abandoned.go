@@ -0,0 +1,97 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/VincentSamuelPaul/production-api/notify"
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+const reminderBatchSize = 100
+
+// AbandonedCarts periodically records carts left untouched for longer than
+// Window and sends a reminder for each one owned by a user.
+type AbandonedCarts struct {
+	Store    structTypes.Storage
+	Notifier notify.Notifier
+	Window   time.Duration
+	Interval time.Duration
+}
+
+// NewAbandonedCarts reads ABANDONED_CART_WINDOW (default 24h) and
+// ABANDONED_CART_SCAN_INTERVAL (default 15m).
+func NewAbandonedCarts(store structTypes.Storage, notifier notify.Notifier) *AbandonedCarts {
+	return &AbandonedCarts{
+		Store:    store,
+		Notifier: notifier,
+		Window:   durationFromEnv("ABANDONED_CART_WINDOW", 24*time.Hour),
+		Interval: durationFromEnv("ABANDONED_CART_SCAN_INTERVAL", 15*time.Minute),
+	}
+}
+
+func (j *AbandonedCarts) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+	for {
+		if err := j.RunOnce(ctx); err != nil {
+			log.Printf("abandoned carts: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *AbandonedCarts) RunOnce(ctx context.Context) error {
+	events, err := j.Store.RecordAbandonedCarts(ctx, time.Now().Add(-j.Window))
+	if err != nil {
+		return err
+	}
+	if len(events) > 0 {
+		log.Printf("abandoned carts: recorded %d", len(events))
+	}
+
+	reminders, err := j.Store.GetPendingCartReminders(ctx, reminderBatchSize)
+	if err != nil {
+		return err
+	}
+	for _, reminder := range reminders {
+		msg := notify.Message{
+			Kind:    "abandoned_cart",
+			UserID:  reminder.UserID,
+			To:      reminder.Email,
+			Subject: "You left something in your cart",
+			Body: fmt.Sprintf("Hi %s, you still have %d item(s) worth %s waiting in your cart.",
+				reminder.Username, reminder.ItemCount, reminder.CartValue),
+			Data: reminder,
+		}
+		if err := j.Notifier.Notify(ctx, msg); err != nil {
+			// left pending, retried on the next scan
+			log.Printf("abandoned carts: notify user %d: %v", reminder.UserID, err)
+			continue
+		}
+		if err := j.Store.MarkCartReminderSent(ctx, reminder.AbandonmentID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("%s: invalid duration %q, using %s", key, v, fallback)
+		return fallback
+	}
+	return d
+}
@@ -0,0 +1,44 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+// IdempotencyPurge periodically deletes idempotency keys older than TTL.
+type IdempotencyPurge struct {
+	Store    structTypes.Storage
+	TTL      time.Duration
+	Interval time.Duration
+}
+
+// NewIdempotencyPurge reads IDEMPOTENCY_KEY_TTL (default 24h), the same
+// setting the API uses to decide a key has expired.
+func NewIdempotencyPurge(store structTypes.Storage) *IdempotencyPurge {
+	return &IdempotencyPurge{
+		Store:    store,
+		TTL:      durationFromEnv("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+		Interval: time.Hour,
+	}
+}
+
+func (j *IdempotencyPurge) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+	for {
+		n, err := j.Store.PurgeIdempotencyKeys(ctx, time.Now().Add(-j.TTL))
+		if err != nil {
+			log.Printf("idempotency purge: %v", err)
+		} else if n > 0 {
+			log.Printf("idempotency purge: deleted %d keys", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
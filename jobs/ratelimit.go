@@ -0,0 +1,48 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// RateLimitPurger is implemented by rate limit stores that keep buckets
+// outside the process. The memory store sweeps its own.
+type RateLimitPurger interface {
+	PurgeRateLimits(ctx context.Context, before time.Time) (int, error)
+}
+
+// RateLimitPurge periodically deletes buckets idle for longer than Idle.
+type RateLimitPurge struct {
+	Store    RateLimitPurger
+	Idle     time.Duration
+	Interval time.Duration
+}
+
+// NewRateLimitPurge reads RATE_LIMIT_IDLE_TTL (default 1h), which should be
+// longer than the period of every configured limit.
+func NewRateLimitPurge(store RateLimitPurger) *RateLimitPurge {
+	return &RateLimitPurge{
+		Store:    store,
+		Idle:     durationFromEnv("RATE_LIMIT_IDLE_TTL", time.Hour),
+		Interval: time.Hour,
+	}
+}
+
+func (j *RateLimitPurge) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+	for {
+		n, err := j.Store.PurgeRateLimits(ctx, time.Now().Add(-j.Idle))
+		if err != nil {
+			log.Printf("rate limit purge: %v", err)
+		} else if n > 0 {
+			log.Printf("rate limit purge: deleted %d buckets", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,13 +1,28 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
 
 	"github.com/VincentSamuelPaul/production-api/api"
+	"github.com/VincentSamuelPaul/production-api/currency"
 	"github.com/VincentSamuelPaul/production-api/database"
+	"github.com/VincentSamuelPaul/production-api/jobs"
+	"github.com/VincentSamuelPaul/production-api/notify"
+	"github.com/VincentSamuelPaul/production-api/payments"
+	"github.com/VincentSamuelPaul/production-api/ratelimit"
+	"github.com/VincentSamuelPaul/production-api/tax"
+	"github.com/VincentSamuelPaul/production-api/telemetry"
 )
 
 func main() {
+	shutdown, err := telemetry.Init(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer shutdown(context.Background())
+
 	store, err := database.NewPostgresStore()
 	if err != nil {
 		log.Fatal(err)
@@ -15,6 +30,44 @@ func main() {
 	if err := store.Init(); err != nil {
 		log.Fatal(err)
 	}
-	server := api.NewAPIServer(":3000", store)
+	calculator, err := tax.FromEnv(store)
+	if err != nil {
+		log.Fatal(err)
+	}
+	store.UseTax(calculator, tax.InclusiveFromEnv())
+	base := currency.BaseFromEnv()
+	store.UseBaseCurrency(base)
+	rates, err := currency.RatesFromFile(base)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(rates) > 0 {
+		if err := store.PutExchangeRates(context.Background(), rates); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("imported %d exchange rates", len(rates))
+	}
+	notifier, err := notify.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go jobs.NewAbandonedCarts(store, notifier).Run(ctx)
+	go jobs.NewIdempotencyPurge(store).Run(ctx)
+
+	var limits ratelimit.Store = ratelimit.NewMemoryStore()
+	if os.Getenv("RATE_LIMIT_STORE") == "postgres" {
+		dbLimits := database.NewRateLimitStore(store.DB)
+		go jobs.NewRateLimitPurge(dbLimits).Run(ctx)
+		limits = dbLimits
+	}
+
+	provider, err := payments.FromEnv(store)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server := api.NewAPIServer(":3000", store, limits, provider)
 	server.Run()
 }
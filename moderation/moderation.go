@@ -0,0 +1,113 @@
+package moderation
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+// Rules decide how new and edited reviews are screened. Values come from:
+//
+//	REVIEW_MIN_LENGTH         shortest comment accepted, in characters (default 0)
+//	REVIEW_MAX_LENGTH         longest comment accepted (default 5000)
+//	REVIEW_BANNED_WORDS       comma separated words or phrases that send a review to moderation
+//	REVIEW_ALLOW_LINKS        accept links in comments without moderation (default false)
+//	REVIEW_AUTO_APPROVE       publish reviews that pass the screen straight away (default true)
+//	REVIEW_REPORT_THRESHOLD   customer reports that flag a published review (default 3)
+type Rules struct {
+	MinLength       int
+	MaxLength       int
+	BannedWords     []string
+	AllowLinks      bool
+	AutoApprove     bool
+	ReportThreshold int
+}
+
+func RulesFromEnv() Rules {
+	rules := Rules{
+		MaxLength:       intFromEnv("REVIEW_MAX_LENGTH", 5000),
+		MinLength:       intFromEnv("REVIEW_MIN_LENGTH", 0),
+		ReportThreshold: intFromEnv("REVIEW_REPORT_THRESHOLD", 3),
+		AutoApprove:     true,
+	}
+	for _, word := range strings.Split(os.Getenv("REVIEW_BANNED_WORDS"), ",") {
+		if word = normalize(word); word != "" {
+			rules.BannedWords = append(rules.BannedWords, word)
+		}
+	}
+	if v, err := strconv.ParseBool(os.Getenv("REVIEW_ALLOW_LINKS")); err == nil {
+		rules.AllowLinks = v
+	}
+	if v, err := strconv.ParseBool(os.Getenv("REVIEW_AUTO_APPROVE")); err == nil {
+		rules.AutoApprove = v
+	}
+	return rules
+}
+
+func intFromEnv(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("%s: invalid value %q, using %d", key, v, fallback)
+		return fallback
+	}
+	return n
+}
+
+// Check enforces the length rules. A comment that breaks them is refused
+// outright rather than queued, since moderation can't fix it.
+func (r Rules) Check(comment string) error {
+	n := utf8.RuneCountInString(strings.TrimSpace(comment))
+	if n < r.MinLength {
+		return fmt.Errorf("comment must be at least %d characters", r.MinLength)
+	}
+	if r.MaxLength > 0 && n > r.MaxLength {
+		return fmt.Errorf("comment must be at most %d characters", r.MaxLength)
+	}
+	return nil
+}
+
+// normalize lower-cases s and reduces it to its words separated by single
+// spaces, so banned phrases match however the comment spaces or punctuates
+// them.
+func normalize(s string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	}), " ")
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\b(https?://|www\.)\S+|\b[a-z0-9-]+\.(com|net|org|io|co|info|biz|ru|xyz)\b`)
+
+// Screen picks the status a review starts in. Reviews containing a banned
+// word or phrase, or a link, are flagged for a moderator, with the reasons as the
+// moderation note; the rest are approved or left pending per AutoApprove.
+func (r Rules) Screen(comment string) (status, note string) {
+	var reasons []string
+	// padding with spaces matches whole words only
+	text := " " + normalize(comment) + " "
+	for _, banned := range r.BannedWords {
+		if strings.Contains(text, " "+banned+" ") {
+			reasons = append(reasons, fmt.Sprintf("contains banned word %q", banned))
+		}
+	}
+	if !r.AllowLinks && linkPattern.MatchString(comment) {
+		reasons = append(reasons, "contains a link")
+	}
+	if len(reasons) > 0 {
+		return structTypes.ReviewFlagged, "pre-screen: " + strings.Join(reasons, ", ")
+	}
+	if r.AutoApprove {
+		return structTypes.ReviewApproved, ""
+	}
+	return structTypes.ReviewPending, ""
+}
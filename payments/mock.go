@@ -0,0 +1,186 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+// Payment method tokens the mock declines. Every other token, including an
+// empty one, is approved.
+const (
+	MockDecline           = "tok_decline"
+	MockInsufficientFunds = "tok_insufficient_funds"
+)
+
+// Mock is a deterministic provider for local development. It keeps no
+// state of its own beyond the process: payments it doesn't hold in memory,
+// such as those from before a restart, are read back from src. References
+// are the order ID and the attempt number, and event IDs the reference and
+// the state the event moves the payment to, so both come out the same on
+// every run. When webhookURL is set every event is also posted there,
+// signed with secret, the way a real gateway would call back.
+type Mock struct {
+	mu       sync.Mutex
+	src      Source
+	payments map[string]*structTypes.Payment
+
+	webhookURL string
+	secret     string
+	client     *http.Client
+}
+
+func NewMock(src Source, webhookURL, secret string) *Mock {
+	return &Mock{
+		src:        src,
+		payments:   map[string]*structTypes.Payment{},
+		webhookURL: webhookURL,
+		secret:     secret,
+		client:     &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (m *Mock) Name() string {
+	return "mock"
+}
+
+func (m *Mock) Authorize(ctx context.Context, req AuthorizeRequest) (structTypes.PaymentEvent, error) {
+	if req.Amount.Sign() <= 0 {
+		return structTypes.PaymentEvent{}, fmt.Errorf("invalid amount %s", req.Amount)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// the next attempt for the order nobody has used yet
+	var reference string
+	for attempt := 1; ; attempt++ {
+		reference = fmt.Sprintf("pay_mock_%d_%d", req.OrderID, attempt)
+		_, err := m.payment(ctx, reference)
+		if errors.Is(err, structTypes.ErrPaymentNotFound) {
+			break
+		}
+		if err != nil {
+			return structTypes.PaymentEvent{}, err
+		}
+	}
+	p := &structTypes.Payment{OrderID: req.OrderID, Provider: m.Name(), Reference: reference}
+	m.payments[p.Reference] = p
+
+	event := structTypes.PaymentEvent{Status: structTypes.PaymentAuthorized, Amount: req.Amount}
+	switch req.Method {
+	case MockDecline:
+		event.Status, event.Reason = structTypes.PaymentFailed, "card declined"
+	case MockInsufficientFunds:
+		event.Status, event.Reason = structTypes.PaymentFailed, "insufficient funds"
+	}
+	return m.apply(p, event)
+}
+
+func (m *Mock) Capture(ctx context.Context, reference string, amount structTypes.Money) (structTypes.PaymentEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, err := m.payment(ctx, reference)
+	if err != nil {
+		return structTypes.PaymentEvent{}, err
+	}
+	if amount.IsZero() {
+		amount = p.Amount
+	}
+	return m.apply(p, structTypes.PaymentEvent{Status: structTypes.PaymentCaptured, Amount: amount})
+}
+
+func (m *Mock) Refund(ctx context.Context, reference string, amount structTypes.Money) (structTypes.PaymentEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, err := m.payment(ctx, reference)
+	if err != nil {
+		return structTypes.PaymentEvent{}, err
+	}
+	if amount.IsZero() {
+		amount = p.CapturedAmount.Sub(p.RefundedAmount)
+	}
+	return m.apply(p, structTypes.PaymentEvent{Status: structTypes.PaymentRefunded, Amount: amount})
+}
+
+func (m *Mock) Void(ctx context.Context, reference string) (structTypes.PaymentEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, err := m.payment(ctx, reference)
+	if err != nil {
+		return structTypes.PaymentEvent{}, err
+	}
+	return m.apply(p, structTypes.PaymentEvent{Status: structTypes.PaymentVoided, Amount: p.Amount})
+}
+
+// payment is the mock's copy of the payment, read from the store the first
+// time it is needed. The caller holds m.mu.
+func (m *Mock) payment(ctx context.Context, reference string) (*structTypes.Payment, error) {
+	if p, ok := m.payments[reference]; ok {
+		return p, nil
+	}
+	if m.src == nil {
+		return nil, fmt.Errorf("%w: reference %s", structTypes.ErrPaymentNotFound, reference)
+	}
+	p, err := m.src.GetPayment(ctx, m.Name(), reference)
+	if err != nil {
+		return nil, err
+	}
+	m.payments[reference] = &p
+	return &p, nil
+}
+
+// apply moves the mock's copy of the payment along and emits the event.
+// The caller holds m.mu.
+func (m *Mock) apply(p *structTypes.Payment, event structTypes.PaymentEvent) (structTypes.PaymentEvent, error) {
+	next, err := Transition(*p, event)
+	if err != nil {
+		return structTypes.PaymentEvent{}, err
+	}
+	*p = next
+
+	// a payment reaches each status once, except for refunds, which the
+	// refunded total tells apart
+	event.ID = fmt.Sprintf("evt_%s_%s", p.Reference, event.Status)
+	if event.Status == structTypes.PaymentRefunded {
+		event.ID += fmt.Sprintf("_%d", p.RefundedAmount.Minor())
+	}
+	event.Reference = p.Reference
+	event.OrderID = p.OrderID
+	event.CreatedAt = time.Now()
+	if m.webhookURL != "" {
+		go m.deliver(event)
+	}
+	return event, nil
+}
+
+func (m *Mock) deliver(event structTypes.PaymentEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("mock payments: %v", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, m.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("mock payments: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(m.secret, time.Now(), body))
+	res, err := m.client.Do(req)
+	if err != nil {
+		log.Printf("mock payments: webhook %s: %v", event.ID, err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		log.Printf("mock payments: webhook %s answered %d", event.ID, res.StatusCode)
+	}
+}
@@ -0,0 +1,151 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+// ErrInvalidTransition is returned for an event that doesn't follow from the
+// payment's current status, such as capturing a voided authorization.
+var ErrInvalidTransition = fmt.Errorf("%w: invalid payment transition", structTypes.ErrConflict)
+
+// AuthorizeRequest asks the provider to hold Amount for the order. Method
+// is the provider's token for the customer's payment method.
+type AuthorizeRequest struct {
+	OrderID  int
+	Amount   structTypes.Money
+	Currency structTypes.Currency
+	Method   string
+}
+
+// PaymentProvider talks to a payment gateway. Declines are reported as an
+// event with status failed; errors mean the provider couldn't be asked or
+// refused the request itself.
+type PaymentProvider interface {
+	Name() string
+	Authorize(ctx context.Context, req AuthorizeRequest) (structTypes.PaymentEvent, error)
+	// Capture collects amount of the authorization, or all of it when amount
+	// is 0.
+	Capture(ctx context.Context, reference string, amount structTypes.Money) (structTypes.PaymentEvent, error)
+	// Refund returns amount of the captured payment, or what is left of it
+	// when amount is 0.
+	Refund(ctx context.Context, reference string, amount structTypes.Money) (structTypes.PaymentEvent, error)
+	Void(ctx context.Context, reference string) (structTypes.PaymentEvent, error)
+}
+
+func invalid(p structTypes.Payment, e structTypes.PaymentEvent) error {
+	from := p.Status
+	if from == "" {
+		from = "new"
+	}
+	return fmt.Errorf("%w: %s payment can't become %s", ErrInvalidTransition, from, e.Status)
+}
+
+// Transition applies the event to the payment. A zero payment stands for a
+// payment the store hasn't seen yet.
+func Transition(p structTypes.Payment, e structTypes.PaymentEvent) (structTypes.Payment, error) {
+	if e.Amount.Sign() < 0 {
+		return p, fmt.Errorf("%w: negative amount", ErrInvalidTransition)
+	}
+	switch e.Status {
+	case structTypes.PaymentAuthorized:
+		if p.Status != "" {
+			return p, invalid(p, e)
+		}
+		p.Amount = e.Amount
+	case structTypes.PaymentFailed:
+		if p.Status != "" && p.Status != structTypes.PaymentAuthorized {
+			return p, invalid(p, e)
+		}
+		if p.Status == "" {
+			p.Amount = e.Amount
+		}
+		p.FailureReason = e.Reason
+	case structTypes.PaymentCaptured:
+		if p.Status != "" && p.Status != structTypes.PaymentAuthorized {
+			return p, invalid(p, e)
+		}
+		if p.Status == "" {
+			p.Amount = e.Amount
+		}
+		if e.Amount.Cmp(p.Amount) > 0 {
+			return p, fmt.Errorf("%w: capture of %s exceeds authorized %s", ErrInvalidTransition, e.Amount, p.Amount)
+		}
+		p.CapturedAmount = e.Amount
+	case structTypes.PaymentVoided:
+		if p.Status != structTypes.PaymentAuthorized {
+			return p, invalid(p, e)
+		}
+	case structTypes.PaymentRefunded:
+		if p.Status != structTypes.PaymentCaptured {
+			return p, invalid(p, e)
+		}
+		if p.RefundedAmount.Add(e.Amount).Cmp(p.CapturedAmount) > 0 {
+			return p, fmt.Errorf("%w: refund of %s exceeds remaining %s", ErrInvalidTransition, e.Amount, p.CapturedAmount.Sub(p.RefundedAmount))
+		}
+		p.RefundedAmount = p.RefundedAmount.Add(e.Amount)
+		if p.RefundedAmount.Cmp(p.CapturedAmount) < 0 {
+			// partial refund, the payment stays captured
+			return p, nil
+		}
+	default:
+		return p, fmt.Errorf("%w: unknown status %q", ErrInvalidTransition, e.Status)
+	}
+	p.Status = e.Status
+	return p, nil
+}
+
+// OrderStatus is the status an order is in once its payment reached p.
+// Partial refunds leave a captured payment's order paid; they show on the
+// payment's refunded amount.
+func OrderStatus(p structTypes.Payment) string {
+	switch p.Status {
+	case structTypes.PaymentAuthorized:
+		return structTypes.OrderAuthorized
+	case structTypes.PaymentCaptured:
+		return structTypes.OrderPaid
+	case structTypes.PaymentRefunded:
+		return structTypes.OrderRefunded
+	case structTypes.PaymentVoided:
+		return structTypes.OrderCancelled
+	case structTypes.PaymentFailed:
+		return structTypes.OrderPaymentFailed
+	}
+	return structTypes.OrderPending
+}
+
+// SetsOrderStatus reports whether payment events still move an order in
+// status. Once an order has shipped or been cancelled it keeps its own
+// status, and later captures, refunds or voids only change the payment.
+func SetsOrderStatus(status string) bool {
+	switch status {
+	case structTypes.OrderPending, structTypes.OrderAuthorized, structTypes.OrderPaid, structTypes.OrderPaymentFailed:
+		return true
+	}
+	return false
+}
+
+// Source looks up the payments the store recorded. Storage implements it.
+type Source interface {
+	GetPayment(ctx context.Context, provider, reference string) (structTypes.Payment, error)
+}
+
+// FromEnv picks the provider named by PAYMENT_PROVIDER (default mock). The
+// mock posts its events to PAYMENTS_WEBHOOK_URL when set, signed with
+// PAYMENTS_WEBHOOK_SECRET.
+func FromEnv(src Source) (PaymentProvider, error) {
+	switch kind := os.Getenv("PAYMENT_PROVIDER"); kind {
+	case "", "mock":
+		return NewMock(src, os.Getenv("PAYMENTS_WEBHOOK_URL"), WebhookSecret()), nil
+	default:
+		return nil, fmt.Errorf("unknown PAYMENT_PROVIDER %q", kind)
+	}
+}
+
+// WebhookSecret is the shared secret webhook deliveries are signed with.
+func WebhookSecret() string {
+	return os.Getenv("PAYMENTS_WEBHOOK_SECRET")
+}
@@ -0,0 +1,166 @@
+package promotions
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+// ErrNotApplicable is wrapped by every reason a code can't be used.
+var ErrNotApplicable = fmt.Errorf("%w: promotion not applicable", structTypes.ErrConflict)
+
+// Line is one product line the promotion is evaluated against.
+type Line struct {
+	ProductID int
+	Category  string
+	Quantity  int
+	UnitPrice structTypes.Money
+}
+
+func (l Line) Total() structTypes.Money {
+	return l.UnitPrice.Mul(l.Quantity)
+}
+
+// Usage is how often the promotion has been redeemed overall and by the
+// user evaluating it. ByUser is ignored when UserID is 0 (guest carts).
+type Usage struct {
+	UserID int
+	Total  int
+	ByUser int
+}
+
+func notApplicable(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrNotApplicable, fmt.Sprintf(format, args...))
+}
+
+// Evaluate checks the promotion against the lines and returns the discount
+// it grants. Only lines matching the product/category scope are discounted;
+// the minimum order value applies to the whole order.
+func Evaluate(promo structTypes.Promotion, lines []Line, usage Usage, now time.Time) (structTypes.AppliedPromotion, error) {
+	applied := structTypes.AppliedPromotion{Code: promo.Code, Description: promo.Description}
+
+	if !promo.Active {
+		return applied, notApplicable("code %s is not active", promo.Code)
+	}
+	if promo.StartsAt != nil && now.Before(*promo.StartsAt) {
+		return applied, notApplicable("code %s is not valid yet", promo.Code)
+	}
+	if promo.EndsAt != nil && !now.Before(*promo.EndsAt) {
+		return applied, notApplicable("code %s has expired", promo.Code)
+	}
+	if promo.MaxUses != nil && usage.Total >= *promo.MaxUses {
+		return applied, notApplicable("code %s has been fully redeemed", promo.Code)
+	}
+	if promo.MaxUsesPerUser != nil && usage.UserID != 0 && usage.ByUser >= *promo.MaxUsesPerUser {
+		return applied, notApplicable("code %s was already used the maximum number of times", promo.Code)
+	}
+
+	var subtotal, eligibleTotal structTypes.Money
+	var eligible []Line
+	for _, line := range lines {
+		subtotal = subtotal.Add(line.Total())
+		if inScope(promo, line) {
+			eligible = append(eligible, line)
+			eligibleTotal = eligibleTotal.Add(line.Total())
+		}
+	}
+	if len(eligible) == 0 {
+		return applied, notApplicable("no items in the order qualify for code %s", promo.Code)
+	}
+	if subtotal.Cmp(promo.MinOrder) < 0 {
+		return applied, notApplicable("code %s requires a minimum order of %s", promo.Code, promo.MinOrder)
+	}
+
+	switch promo.Kind {
+	case structTypes.PromotionPercentage:
+		applied.Discount = eligibleTotal.Percent(promo.PercentBasisPoints)
+	case structTypes.PromotionFixed:
+		applied.Discount = promo.AmountOff.Min(eligibleTotal)
+	case structTypes.PromotionFreeShipping:
+		applied.FreeShipping = true
+	case structTypes.PromotionBuyXGetY:
+		applied.Discount = buyXGetY(promo, eligible)
+		if applied.Discount.IsZero() {
+			return applied, notApplicable("buy %d to get %d free with code %s", promo.BuyQuantity, promo.GetQuantity, promo.Code)
+		}
+	default:
+		return applied, fmt.Errorf("unknown promotion kind %q", promo.Kind)
+	}
+	return applied, nil
+}
+
+// buyXGetY gives GetQuantity units free for every BuyQuantity+GetQuantity
+// units of the same product.
+func buyXGetY(promo structTypes.Promotion, lines []Line) structTypes.Money {
+	group := promo.BuyQuantity + promo.GetQuantity
+	if promo.BuyQuantity <= 0 || promo.GetQuantity <= 0 {
+		return structTypes.Money{}
+	}
+	var discount structTypes.Money
+	for _, line := range lines {
+		free := line.Quantity / group * promo.GetQuantity
+		discount = discount.Add(line.UnitPrice.Mul(free))
+	}
+	return discount
+}
+
+func inScope(promo structTypes.Promotion, line Line) bool {
+	if len(promo.ProductIDs) == 0 && len(promo.Categories) == 0 {
+		return true
+	}
+	for _, id := range promo.ProductIDs {
+		if id == line.ProductID {
+			return true
+		}
+	}
+	for _, category := range promo.Categories {
+		if category == line.Category {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks a promotion definition before it is stored.
+func Validate(promo structTypes.Promotion) error {
+	if promo.Code == "" {
+		return errors.New("code is required")
+	}
+	switch promo.Kind {
+	case structTypes.PromotionPercentage:
+		if promo.PercentBasisPoints <= 0 || promo.PercentBasisPoints > 10000 {
+			return errors.New("percent_bps must be between 1 and 10000")
+		}
+	case structTypes.PromotionFixed:
+		if promo.AmountOff.Sign() <= 0 {
+			return errors.New("amount_off must be positive")
+		}
+	case structTypes.PromotionFreeShipping:
+	case structTypes.PromotionBuyXGetY:
+		if promo.BuyQuantity <= 0 || promo.GetQuantity <= 0 {
+			return errors.New("buy_quantity and get_quantity must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown promotion kind %q", promo.Kind)
+	}
+	if promo.StartsAt != nil && promo.EndsAt != nil && !promo.EndsAt.After(*promo.StartsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	return nil
+}
+
+// CartLines converts cart items, priced at their snapshot, to lines.
+func CartLines(items []structTypes.CartProduct) []Line {
+	lines := make([]Line, 0, len(items))
+	for _, item := range items {
+		lines = append(lines, Line{
+			ProductID: item.ProductID,
+			Category:  item.Category,
+			Quantity:  item.Quantity,
+			UnitPrice: item.Price,
+		})
+	}
+	return lines
+}
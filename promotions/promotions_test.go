@@ -0,0 +1,114 @@
+package promotions
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+func usd(minor int64) structTypes.Money {
+	return structTypes.NewMoney(minor, "USD")
+}
+
+func TestEvaluate(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	earlier, later := now.Add(-time.Hour), now.Add(time.Hour)
+	one, two := 1, 2
+	lines := []Line{
+		{ProductID: 1, Category: "shoes", Quantity: 2, UnitPrice: usd(1999)},
+		{ProductID: 2, Category: "socks", Quantity: 1, UnitPrice: usd(500)},
+	}
+	percent := func(bps int64) structTypes.Promotion {
+		return structTypes.Promotion{Code: "SAVE", Kind: structTypes.PromotionPercentage, PercentBasisPoints: bps, Active: true}
+	}
+	tests := []struct {
+		name     string
+		promo    structTypes.Promotion
+		lines    []Line
+		usage    Usage
+		discount int64
+		free     bool
+		wantErr  bool
+	}{
+		{name: "percentage", promo: percent(1000), discount: 450},
+		{name: "percentage of a category", promo: func() structTypes.Promotion {
+			p := percent(1000)
+			p.Categories = []string{"socks"}
+			return p
+		}(), discount: 50},
+		{name: "fixed", promo: structTypes.Promotion{Code: "TEN", Kind: structTypes.PromotionFixed, AmountOff: usd(1000), Active: true}, discount: 1000},
+		{name: "fixed capped at the eligible total", promo: structTypes.Promotion{
+			Code: "TEN", Kind: structTypes.PromotionFixed, AmountOff: usd(1000), ProductIDs: []int{2}, Active: true,
+		}, discount: 500},
+		{name: "free shipping", promo: structTypes.Promotion{Code: "SHIP", Kind: structTypes.PromotionFreeShipping, Active: true}, free: true},
+		{name: "buy 2 get 1", promo: structTypes.Promotion{
+			Code: "B2G1", Kind: structTypes.PromotionBuyXGetY, BuyQuantity: 2, GetQuantity: 1, Active: true,
+		}, lines: []Line{{ProductID: 1, Quantity: 7, UnitPrice: usd(1999)}}, discount: 3998},
+		{name: "buy 2 get 1 without enough units", promo: structTypes.Promotion{
+			Code: "B2G1", Kind: structTypes.PromotionBuyXGetY, BuyQuantity: 2, GetQuantity: 1, Active: true,
+		}, wantErr: true},
+		{name: "minimum order met", promo: func() structTypes.Promotion {
+			p := percent(1000)
+			p.MinOrder = usd(4498)
+			return p
+		}(), discount: 450},
+		{name: "minimum order not met", promo: func() structTypes.Promotion {
+			p := percent(1000)
+			p.MinOrder = usd(4499)
+			return p
+		}(), wantErr: true},
+		{name: "inactive", promo: structTypes.Promotion{Code: "OFF", Kind: structTypes.PromotionPercentage, PercentBasisPoints: 1000}, wantErr: true},
+		{name: "not started", promo: func() structTypes.Promotion {
+			p := percent(1000)
+			p.StartsAt = &later
+			return p
+		}(), wantErr: true},
+		{name: "expired", promo: func() structTypes.Promotion {
+			p := percent(1000)
+			p.StartsAt, p.EndsAt = &earlier, &now
+			return p
+		}(), wantErr: true},
+		{name: "fully redeemed", promo: func() structTypes.Promotion {
+			p := percent(1000)
+			p.MaxUses = &two
+			return p
+		}(), usage: Usage{Total: 2}, wantErr: true},
+		{name: "used up by the user", promo: func() structTypes.Promotion {
+			p := percent(1000)
+			p.MaxUsesPerUser = &one
+			return p
+		}(), usage: Usage{UserID: 7, Total: 1, ByUser: 1}, wantErr: true},
+		{name: "per user limit skipped for guests", promo: func() structTypes.Promotion {
+			p := percent(1000)
+			p.MaxUsesPerUser = &one
+			return p
+		}(), usage: Usage{Total: 1, ByUser: 1}, discount: 450},
+		{name: "nothing in scope", promo: func() structTypes.Promotion {
+			p := percent(1000)
+			p.ProductIDs = []int{99}
+			return p
+		}(), wantErr: true},
+	}
+	for _, tt := range tests {
+		l := tt.lines
+		if l == nil {
+			l = lines
+		}
+		got, err := Evaluate(tt.promo, l, tt.usage, now)
+		if tt.wantErr {
+			if !errors.Is(err, ErrNotApplicable) {
+				t.Errorf("%s: err = %v, want ErrNotApplicable", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got.Discount.Cmp(usd(tt.discount)) != 0 || got.FreeShipping != tt.free {
+			t.Errorf("%s: discount %s, free shipping %t, want %s, %t", tt.name, got.Discount, got.FreeShipping, usd(tt.discount), tt.free)
+		}
+	}
+}
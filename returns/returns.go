@@ -0,0 +1,66 @@
+package returns
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+const defaultWindowDays = 30
+
+// WindowFromEnv reads RETURN_WINDOW_DAYS, how many days after delivery items
+// can be returned (default 30).
+func WindowFromEnv() time.Duration {
+	days := defaultWindowDays
+	if v := os.Getenv("RETURN_WINDOW_DAYS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Printf("RETURN_WINDOW_DAYS: invalid value %q, using %d", v, defaultWindowDays)
+		} else {
+			days = n
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// Eligible reports whether items of an order in this status can be returned.
+// Refunds no longer change the order status, but orders recorded as partly
+// refunded before that stay returnable for the rest.
+func Eligible(status string) bool {
+	return status == structTypes.OrderDelivered || status == structTypes.OrderPartiallyRefunded
+}
+
+// LineRefund is what returning qty units bought at unit price gives back.
+// An order-level discount is shared across lines in proportion to their
+// value.
+func LineRefund(unit structTypes.Money, qty int, subtotal, discount structTypes.Money) structTypes.Money {
+	value := unit.Mul(qty)
+	if discount.Sign() <= 0 || subtotal.Sign() <= 0 {
+		return value
+	}
+	return value.Sub(discount.Prorate(value, subtotal))
+}
+
+// TaxShare is the part of a line's tax that qty of its bought units carry.
+func TaxShare(lineTax structTypes.Money, qty, bought int) structTypes.Money {
+	if bought <= 0 {
+		return structTypes.NewMoney(0, lineTax.Currency())
+	}
+	return lineTax.Share(int64(qty), int64(bought))
+}
+
+// Next checks a move of the return from one status to another.
+func Next(from, to string) bool {
+	switch to {
+	case structTypes.ReturnApproved, structTypes.ReturnRejected:
+		return from == structTypes.ReturnRequested
+	case structTypes.ReturnReceived:
+		return from == structTypes.ReturnApproved
+	case structTypes.ReturnRefunded:
+		return from == structTypes.ReturnReceived
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package shipping
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+// rateFor picks the rate of the method that applies to the destination and
+// weight. Rates listing the country win over catch-all rates; within each
+// group the first rate whose weight band fits is used.
+func rateFor(method structTypes.ShippingMethod, country string, weightGrams int) (structTypes.ShippingRate, bool) {
+	fits := func(rate structTypes.ShippingRate) bool {
+		return weightGrams >= rate.MinWeightGrams && (rate.MaxWeightGrams == 0 || weightGrams <= rate.MaxWeightGrams)
+	}
+	for _, rate := range method.Rates {
+		if slices.Contains(rate.Countries, country) && fits(rate) {
+			return rate, true
+		}
+	}
+	for _, rate := range method.Rates {
+		if len(rate.Countries) == 0 && fits(rate) {
+			return rate, true
+		}
+	}
+	return structTypes.ShippingRate{}, false
+}
+
+// Quote prices shipping an order of the given weight and value with the
+// method. Started kilograms are charged in full. It reports false when the
+// method doesn't ship there or that weight.
+func Quote(method structTypes.ShippingMethod, country string, weightGrams int, value structTypes.Money) (structTypes.Money, bool) {
+	rate, ok := rateFor(method, strings.ToUpper(country), weightGrams)
+	if !ok {
+		return structTypes.Money{}, false
+	}
+	if method.FreeOver.Sign() > 0 && value.Cmp(method.FreeOver) >= 0 {
+		return structTypes.Money{}, true
+	}
+	kilograms := (weightGrams + 999) / 1000
+	return rate.Base.Add(rate.PerKg.Mul(kilograms)), true
+}
+
+// Quotes prices every method that can ship the order, cheapest first.
+func Quotes(methods []structTypes.ShippingMethod, country string, weightGrams int, value structTypes.Money) []structTypes.ShippingQuote {
+	quotes := []structTypes.ShippingQuote{}
+	for _, method := range methods {
+		cost, ok := Quote(method, country, weightGrams, value)
+		if !ok {
+			continue
+		}
+		quotes = append(quotes, structTypes.ShippingQuote{
+			Method:        method.Code,
+			Name:          method.Name,
+			Cost:          cost,
+			EstimatedDays: method.EstimatedDays,
+		})
+	}
+	slices.SortStableFunc(quotes, func(a, b structTypes.ShippingQuote) int {
+		return a.Cost.Cmp(b.Cost)
+	})
+	return quotes
+}
+
+// Validate checks a shipping method before it is saved.
+func Validate(method structTypes.ShippingMethod) error {
+	if strings.TrimSpace(method.Code) == "" || strings.TrimSpace(method.Name) == "" {
+		return fmt.Errorf("code and name are required")
+	}
+	if len(method.Rates) == 0 {
+		return fmt.Errorf("at least one rate is required")
+	}
+	if method.FreeOver.Sign() < 0 {
+		return fmt.Errorf("free_over can't be negative")
+	}
+	for i, rate := range method.Rates {
+		if rate.Base.Sign() < 0 || rate.PerKg.Sign() < 0 {
+			return fmt.Errorf("rate %d: amounts can't be negative", i)
+		}
+		if rate.MinWeightGrams < 0 || (rate.MaxWeightGrams != 0 && rate.MaxWeightGrams < rate.MinWeightGrams) {
+			return fmt.Errorf("rate %d: invalid weight band", i)
+		}
+		for _, country := range rate.Countries {
+			if len(country) != 2 {
+				return fmt.Errorf("rate %d: invalid country %q", i, country)
+			}
+		}
+	}
+	return nil
+}
+
+// Weight is the total weight of the cart lines in grams.
+func Weight(items []structTypes.CartProduct) int {
+	grams := 0
+	for _, item := range items {
+		grams += item.WeightGrams * item.Quantity
+	}
+	return grams
+}
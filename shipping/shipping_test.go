@@ -0,0 +1,95 @@
+package shipping
+
+import (
+	"slices"
+	"testing"
+
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+func usd(minor int64) structTypes.Money {
+	return structTypes.NewMoney(minor, "USD")
+}
+
+var standard = structTypes.ShippingMethod{
+	Code: "standard",
+	Name: "Standard",
+	Rates: []structTypes.ShippingRate{
+		{Base: usd(1500), PerKg: usd(200)},
+		{Countries: []string{"US"}, MaxWeightGrams: 2000, Base: usd(500)},
+		{Countries: []string{"US"}, MinWeightGrams: 2001, MaxWeightGrams: 30000, Base: usd(500), PerKg: usd(100)},
+	},
+}
+
+func TestQuote(t *testing.T) {
+	freeOver := standard
+	freeOver.FreeOver = usd(5000)
+	domestic := standard
+	domestic.Rates = standard.Rates[1:]
+	tests := []struct {
+		name        string
+		method      structTypes.ShippingMethod
+		country     string
+		weightGrams int
+		value       structTypes.Money
+		want        int64
+		ok          bool
+	}{
+		{name: "country rate beats catch-all", method: standard, country: "us", weightGrams: 1500, want: 500, ok: true},
+		{name: "started kilograms charged in full", method: standard, country: "US", weightGrams: 2001, want: 800, ok: true},
+		{name: "upper weight band", method: standard, country: "US", weightGrams: 30000, want: 3500, ok: true},
+		{name: "over the country bands falls back", method: standard, country: "US", weightGrams: 30001, want: 7700, ok: true},
+		{name: "catch-all", method: standard, country: "DE", weightGrams: 1000, want: 1700, ok: true},
+		{name: "catch-all with no weight", method: standard, country: "DE", want: 1500, ok: true},
+		{name: "free at the threshold", method: freeOver, country: "DE", weightGrams: 1000, value: usd(5000), want: 0, ok: true},
+		{name: "free over the threshold", method: freeOver, country: "US", weightGrams: 1000, value: usd(9999), want: 0, ok: true},
+		{name: "just below the threshold", method: freeOver, country: "DE", weightGrams: 1000, value: usd(4999), want: 1700, ok: true},
+		{name: "no threshold", method: standard, country: "DE", weightGrams: 1000, value: usd(100000), want: 1700, ok: true},
+		{name: "country not served", method: domestic, country: "DE", weightGrams: 1000},
+		{name: "weight not served", method: domestic, country: "US", weightGrams: 30001},
+		{name: "free over doesn't reach unserved countries", method: func() structTypes.ShippingMethod {
+			m := domestic
+			m.FreeOver = usd(5000)
+			return m
+		}(), country: "DE", value: usd(9999)},
+	}
+	for _, tt := range tests {
+		got, ok := Quote(tt.method, tt.country, tt.weightGrams, tt.value)
+		if ok != tt.ok {
+			t.Errorf("%s: ok = %t, want %t", tt.name, ok, tt.ok)
+			continue
+		}
+		if ok && got.Cmp(usd(tt.want)) != 0 {
+			t.Errorf("%s: cost = %s, want %s", tt.name, got, usd(tt.want))
+		}
+	}
+}
+
+func TestQuotes(t *testing.T) {
+	express := structTypes.ShippingMethod{
+		Code:  "express",
+		Name:  "Express",
+		Rates: []structTypes.ShippingRate{{Base: usd(1200)}},
+	}
+	domestic := standard
+	domestic.Code = "domestic"
+	domestic.Rates = standard.Rates[1:]
+	methods := []structTypes.ShippingMethod{standard, express, domestic}
+	tests := []struct {
+		country string
+		want    []string
+	}{
+		{"US", []string{"standard", "domestic", "express"}},
+		{"DE", []string{"express", "standard"}},
+	}
+	for _, tt := range tests {
+		quotes := Quotes(methods, tt.country, 1000, usd(0))
+		var got []string
+		for _, q := range quotes {
+			got = append(got, q.Method)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Quotes(%s) = %v, want %v", tt.country, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package tax
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+// Tax classes with a fixed meaning. Any other class is looked up in the
+// rate table like the standard one.
+const (
+	ClassStandard = ""
+	ClassExempt   = "exempt"
+	// ClassShipping is the class shipping charges are taxed under. It falls
+	// back to the standard rate when the jurisdiction has no shipping rate.
+	ClassShipping = "shipping"
+)
+
+// Line is one taxable order line. Amount is what the customer is charged
+// for it after discounts.
+type Line struct {
+	ProductID int
+	TaxClass  string
+	Amount    structTypes.Money
+}
+
+// Request asks for the tax of an order shipped to Country/Region. With
+// Inclusive set, amounts already contain tax and it is taken out of them
+// instead of added on top.
+type Request struct {
+	Country    string
+	Region     string
+	PostalCode string
+	Lines      []Line
+	Shipping   structTypes.Money
+	Inclusive  bool
+	// Currency decides how tax is rounded.
+	Currency structTypes.Currency
+}
+
+type LineTax struct {
+	Tax             structTypes.Money
+	RateBasisPoints int64
+}
+
+// Result has one LineTax per request line, in order.
+type Result struct {
+	Lines    []LineTax
+	Shipping LineTax
+	Total    structTypes.Money
+}
+
+// Calculator works out the tax of an order. The shop's own rate table is
+// used through Local; an external tax service only has to implement this.
+type Calculator interface {
+	Calculate(ctx context.Context, req Request) (Result, error)
+}
+
+// RateSource supplies the rate table. Storage implements it.
+type RateSource interface {
+	GetTaxRates(ctx context.Context) ([]structTypes.TaxRate, error)
+}
+
+// Local calculates tax from the rates kept in the database.
+type Local struct {
+	Rates RateSource
+}
+
+func (l Local) Calculate(ctx context.Context, req Request) (Result, error) {
+	rates, err := l.Rates.GetTaxRates(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	return Compute(rates, req), nil
+}
+
+// None charges no tax at all.
+type None struct{}
+
+func (None) Calculate(ctx context.Context, req Request) (Result, error) {
+	return Result{Lines: make([]LineTax, len(req.Lines))}, nil
+}
+
+// Compute applies the rate table to the request.
+func Compute(rates []structTypes.TaxRate, req Request) Result {
+	country, region := strings.ToUpper(req.Country), strings.ToUpper(req.Region)
+	result := Result{Lines: make([]LineTax, len(req.Lines))}
+	for i, line := range req.Lines {
+		bps := Rate(rates, country, region, line.TaxClass)
+		result.Lines[i] = LineTax{Tax: Amount(line.Amount, bps, req.Inclusive).Round(req.Currency), RateBasisPoints: bps}
+		result.Total = result.Total.Add(result.Lines[i].Tax)
+	}
+	if req.Shipping.Sign() > 0 {
+		bps := Rate(rates, country, region, ClassShipping)
+		result.Shipping = LineTax{Tax: Amount(req.Shipping, bps, req.Inclusive).Round(req.Currency), RateBasisPoints: bps}
+		result.Total = result.Total.Add(result.Shipping.Tax)
+	}
+	return result
+}
+
+// Rate finds the rate for a class in a jurisdiction. A rate for the region
+// beats one for the whole country; a class without its own rate pays the
+// standard rate, except exempt which never pays tax.
+func Rate(rates []structTypes.TaxRate, country, region, class string) int64 {
+	if class == ClassExempt {
+		return 0
+	}
+	lookup := func(class string) (int64, bool) {
+		var countryRate *structTypes.TaxRate
+		for i, rate := range rates {
+			if rate.Country != country || rate.TaxClass != class {
+				continue
+			}
+			if rate.Region != "" && rate.Region == region {
+				return rate.RateBasisPoints, true
+			}
+			if rate.Region == "" {
+				countryRate = &rates[i]
+			}
+		}
+		if countryRate != nil {
+			return countryRate.RateBasisPoints, true
+		}
+		return 0, false
+	}
+	if bps, ok := lookup(class); ok || class == ClassStandard {
+		return bps
+	}
+	bps, _ := lookup(ClassStandard)
+	return bps
+}
+
+// Amount is the tax on amount at the rate. Inclusive amounts contain the
+// tax already, so it is the part of amount above its net value.
+func Amount(amount structTypes.Money, basisPoints int64, inclusive bool) structTypes.Money {
+	if basisPoints == 0 || amount.IsZero() {
+		return structTypes.NewMoney(0, amount.Currency())
+	}
+	if !inclusive {
+		return amount.Percent(basisPoints)
+	}
+	return amount.Sub(amount.Share(10000, 10000+basisPoints))
+}
+
+// Allocate spreads an order discount over the line amounts in proportion to
+// their value. The last line takes the rounding remainder so the shares add
+// up to the discount exactly.
+func Allocate(amounts []structTypes.Money, discount structTypes.Money) []structTypes.Money {
+	net := make([]structTypes.Money, len(amounts))
+	copy(net, amounts)
+	var total structTypes.Money
+	for _, amount := range amounts {
+		total = total.Add(amount)
+	}
+	if discount.Sign() <= 0 || total.Sign() <= 0 {
+		return net
+	}
+	left := discount
+	for i, amount := range amounts {
+		share := discount.Prorate(amount, total)
+		if i == len(amounts)-1 || share.Cmp(left) > 0 {
+			share = left
+		}
+		net[i] = net[i].Sub(share)
+		left = left.Sub(share)
+	}
+	return net
+}
+
+// InclusiveFromEnv reads TAX_PRICES_INCLUDE_TAX: when true product prices
+// are gross and tax is contained in them, otherwise tax is added at
+// checkout.
+func InclusiveFromEnv() bool {
+	v, _ := strconv.ParseBool(os.Getenv("TAX_PRICES_INCLUDE_TAX"))
+	return v
+}
+
+// FromEnv picks the calculator named by TAX_PROVIDER (local or none, default
+// local).
+func FromEnv(rates RateSource) (Calculator, error) {
+	switch kind := os.Getenv("TAX_PROVIDER"); kind {
+	case "", "local":
+		return Local{Rates: rates}, nil
+	case "none":
+		return None{}, nil
+	default:
+		return nil, fmt.Errorf("unknown TAX_PROVIDER %q", kind)
+	}
+}
@@ -0,0 +1,148 @@
+package tax
+
+import (
+	"testing"
+
+	structTypes "github.com/VincentSamuelPaul/production-api/types"
+)
+
+func usd(minor int64) structTypes.Money {
+	return structTypes.NewMoney(minor, "USD")
+}
+
+var testRates = []structTypes.TaxRate{
+	{Country: "DE", RateBasisPoints: 1900},
+	{Country: "DE", TaxClass: "reduced", RateBasisPoints: 700},
+	{Country: "US", RateBasisPoints: 500},
+	{Country: "US", Region: "CA", RateBasisPoints: 725},
+	{Country: "US", TaxClass: ClassShipping, RateBasisPoints: 0},
+}
+
+func TestRate(t *testing.T) {
+	tests := []struct {
+		country, region, class string
+		want                   int64
+	}{
+		{"DE", "", ClassStandard, 1900},
+		{"DE", "", "reduced", 700},
+		// a class without its own rate pays the standard rate
+		{"DE", "", "books", 1900},
+		{"DE", "", ClassShipping, 1900},
+		{"DE", "", ClassExempt, 0},
+		// the region's rate beats the country's
+		{"US", "CA", ClassStandard, 725},
+		{"US", "NY", ClassStandard, 500},
+		{"US", "CA", "reduced", 725},
+		// the class's own country rate beats the region's standard rate
+		{"US", "CA", ClassShipping, 0},
+		{"FR", "", ClassStandard, 0},
+	}
+	for _, tt := range tests {
+		if got := Rate(testRates, tt.country, tt.region, tt.class); got != tt.want {
+			t.Errorf("Rate(%s, %q, %q) = %d, want %d", tt.country, tt.region, tt.class, got, tt.want)
+		}
+	}
+}
+
+func TestAmount(t *testing.T) {
+	tests := []struct {
+		amount      structTypes.Money
+		basisPoints int64
+		inclusive   bool
+		want        structTypes.Money
+	}{
+		{usd(1000), 1900, false, usd(190)},
+		{usd(1190), 1900, true, usd(190)},
+		{usd(1999), 825, false, usd(165)},
+		{usd(1999), 825, true, usd(152)},
+		{usd(1999), 0, false, usd(0)},
+		{usd(0), 1900, true, usd(0)},
+		{structTypes.NewMoney(1000, "JPY"), 1000, false, structTypes.NewMoney(100, "JPY")},
+		{structTypes.NewMoney(1000, "JPY"), 1000, true, structTypes.NewMoney(91, "JPY")},
+		{structTypes.NewMoney(1234, "KWD"), 500, false, structTypes.NewMoney(62, "KWD")},
+	}
+	for _, tt := range tests {
+		if got := Amount(tt.amount, tt.basisPoints, tt.inclusive); got != tt.want {
+			t.Errorf("Amount(%s %s, %d, %t) = %s, want %s", tt.amount, tt.amount.Currency(), tt.basisPoints, tt.inclusive, got, tt.want)
+		}
+	}
+}
+
+func TestCompute(t *testing.T) {
+	lines := []Line{
+		{ProductID: 1, Amount: usd(1000)},
+		{ProductID: 2, TaxClass: "reduced", Amount: usd(500)},
+		{ProductID: 3, TaxClass: ClassExempt, Amount: usd(300)},
+	}
+	tests := []struct {
+		name      string
+		country   string
+		region    string
+		shipping  structTypes.Money
+		inclusive bool
+		lines     []int64
+		ship      int64
+		total     int64
+	}{
+		{name: "exclusive", country: "de", shipping: usd(490), lines: []int64{190, 35, 0}, ship: 93, total: 318},
+		{name: "inclusive", country: "DE", shipping: usd(490), inclusive: true, lines: []int64{160, 33, 0}, ship: 78, total: 271},
+		{name: "no shipping", country: "DE", lines: []int64{190, 35, 0}, total: 225},
+		{name: "region", country: "US", region: "ca", shipping: usd(490), lines: []int64{73, 36, 0}, ship: 0, total: 109},
+		{name: "no rates", country: "FR", shipping: usd(490), lines: []int64{0, 0, 0}, total: 0},
+	}
+	for _, tt := range tests {
+		got := Compute(testRates, Request{
+			Country:   tt.country,
+			Region:    tt.region,
+			Lines:     lines,
+			Shipping:  tt.shipping,
+			Inclusive: tt.inclusive,
+			Currency:  "USD",
+		})
+		for i, want := range tt.lines {
+			if got.Lines[i].Tax.Cmp(usd(want)) != 0 {
+				t.Errorf("%s: line %d tax = %s, want %s", tt.name, i, got.Lines[i].Tax, usd(want))
+			}
+		}
+		if got.Shipping.Tax.Cmp(usd(tt.ship)) != 0 {
+			t.Errorf("%s: shipping tax = %s, want %s", tt.name, got.Shipping.Tax, usd(tt.ship))
+		}
+		if got.Total.Cmp(usd(tt.total)) != 0 {
+			t.Errorf("%s: total = %s, want %s", tt.name, got.Total, usd(tt.total))
+		}
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		amounts  []int64
+		discount int64
+		want     []int64
+	}{
+		{[]int64{1000, 1000, 1000}, 1000, []int64{667, 667, 666}},
+		{[]int64{100, 200}, 1, []int64{100, 199}},
+		{[]int64{100, 100, 100}, 2, []int64{99, 99, 100}},
+		{[]int64{1999, 501, 0}, 250, []int64{1799, 451, 0}},
+		{[]int64{1000, 500}, 0, []int64{1000, 500}},
+		{[]int64{0, 0}, 100, []int64{0, 0}},
+	}
+	for _, tt := range tests {
+		amounts := make([]structTypes.Money, len(tt.amounts))
+		for i, v := range tt.amounts {
+			amounts[i] = usd(v)
+		}
+		got := Allocate(amounts, usd(tt.discount))
+		var total, taken structTypes.Money
+		for i, want := range tt.want {
+			if got[i].Cmp(usd(want)) != 0 {
+				t.Errorf("Allocate(%v, %d)[%d] = %s, want %s", tt.amounts, tt.discount, i, got[i], usd(want))
+			}
+			total = total.Add(amounts[i])
+			taken = taken.Add(amounts[i].Sub(got[i]))
+		}
+		// the shares add up to the discount exactly, whatever the rounding
+		if total.Sign() > 0 && taken.Cmp(usd(tt.discount)) != 0 {
+			t.Errorf("Allocate(%v, %d) took %s in total, want the whole discount", tt.amounts, tt.discount, taken)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package structTypes
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotFound is wrapped by every "does not exist" error returned from
+// Storage, so handlers can answer 404 without knowing the entity.
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict is wrapped by errors caused by the current state of a
+// resource, such as asking for more stock than is available.
+var ErrConflict = errors.New("conflict")
+
+var (
+	ErrUserNotFound      = fmt.Errorf("user %w", ErrNotFound)
+	ErrProductNotFound   = fmt.Errorf("product %w", ErrNotFound)
+	ErrCartNotFound      = fmt.Errorf("cart %w", ErrNotFound)
+	ErrCartItemNotFound  = fmt.Errorf("cart item %w", ErrNotFound)
+	ErrOrderNotFound     = fmt.Errorf("order %w", ErrNotFound)
+	ErrWishlistNotFound  = fmt.Errorf("wishlist %w", ErrNotFound)
+	ErrPromotionNotFound = fmt.Errorf("promotion %w", ErrNotFound)
+	ErrPaymentNotFound   = fmt.Errorf("payment %w", ErrNotFound)
+	ErrReturnNotFound    = fmt.Errorf("return %w", ErrNotFound)
+	ErrAddressNotFound   = fmt.Errorf("address %w", ErrNotFound)
+	ErrShipmentNotFound  = fmt.Errorf("shipment %w", ErrNotFound)
+	ErrTaxRateNotFound   = fmt.Errorf("tax rate %w", ErrNotFound)
+	ErrReviewNotFound    = fmt.Errorf("review %w", ErrNotFound)
+
+	ErrExchangeRateNotFound = fmt.Errorf("exchange rate %w", ErrNotFound)
+	ErrProductPriceNotFound = fmt.Errorf("product price %w", ErrNotFound)
+
+	ErrShippingMethodNotFound = fmt.Errorf("shipping method %w", ErrNotFound)
+
+	ErrWishlistItemNotFound = fmt.Errorf("wishlist item %w", ErrNotFound)
+)
+
+var (
+	ErrOutOfStock          = fmt.Errorf("%w: product is out of stock", ErrConflict)
+	ErrInsufficientStock   = fmt.Errorf("%w: not enough stock", ErrConflict)
+	ErrProductDiscontinued = fmt.Errorf("%w: product is discontinued", ErrConflict)
+	ErrOrderNotCancellable = fmt.Errorf("%w: order can't be cancelled", ErrConflict)
+	ErrReturnNotAllowed    = fmt.Errorf("%w: return not allowed", ErrConflict)
+	ErrShippingUnavailable = fmt.Errorf("%w: no shipping method ships this order", ErrConflict)
+	ErrOrderNotShippable   = fmt.Errorf("%w: order can't be shipped", ErrConflict)
+	ErrOrderTransition     = fmt.Errorf("%w: order status can't be changed that way", ErrConflict)
+
+	ErrPaymentReferenceConflict = fmt.Errorf("%w: payment reference belongs to another order", ErrConflict)
+
+	ErrIdempotencyKeyReused   = fmt.Errorf("%w: idempotency key was used for a different request", ErrConflict)
+	ErrIdempotencyKeyInFlight = fmt.Errorf("%w: a request with this idempotency key is still in progress", ErrConflict)
+)
+
+var (
+	ErrShippingAddressRequired = errors.New("a shipping address is required")
+	ErrCurrencyNotSupported    = errors.New("currency not supported")
+)
@@ -0,0 +1,464 @@
+package structTypes
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount of a currency, counted in the currency's minor unit:
+// cents for USD, whole yen for JPY, fils for KWD. It scans from NUMERIC
+// columns and encodes to JSON as a decimal number, so amounts never pass
+// through float64 arithmetic.
+//
+// Columns and bare JSON numbers don't say which currency they are in, so
+// amounts read from them have none until Round gives them the one the
+// caller knows they are in. Until then they count in thousandths, enough
+// for every currency. Arithmetic gives such an amount the currency of the
+// other operand; mixing two different currencies is a bug and panics, as
+// that needs Convert.
+//
+// Rounding rules: parsing, percentages and Round round half away from zero
+// to the minor unit. Nothing else rounds, so sums of rounded amounts are
+// exact.
+type Money struct {
+	minor    int64
+	currency Currency
+}
+
+// Currency is an ISO 4217 code such as "USD".
+type Currency string
+
+const DefaultCurrency Currency = "USD"
+
+// unknownDecimals is the precision amounts without a currency are kept in,
+// the most any currency has.
+const unknownDecimals = 3
+
+var (
+	// zeroDecimalCurrencies have no minor unit, so amounts are whole units.
+	zeroDecimalCurrencies = map[Currency]bool{
+		"BIF": true, "CLP": true, "DJF": true, "GNF": true, "ISK": true, "JPY": true, "KMF": true, "KRW": true,
+		"PYG": true, "RWF": true, "UGX": true, "VND": true, "VUV": true, "XAF": true, "XOF": true, "XPF": true,
+	}
+	// threeDecimalCurrencies have a minor unit of a thousandth.
+	threeDecimalCurrencies = map[Currency]bool{
+		"BHD": true, "IQD": true, "JOD": true, "KWD": true, "LYD": true, "OMR": true, "TND": true,
+	}
+)
+
+// ParseCurrency upper-cases s and checks it looks like an ISO 4217 code.
+func ParseCurrency(s string) (Currency, error) {
+	c := Currency(strings.ToUpper(strings.TrimSpace(s)))
+	if len(c) != 3 {
+		return "", fmt.Errorf("invalid currency %q", s)
+	}
+	for _, r := range c {
+		if r < 'A' || r > 'Z' {
+			return "", fmt.Errorf("invalid currency %q", s)
+		}
+	}
+	return c, nil
+}
+
+// Decimals is the number of decimal places of the currency's minor unit.
+func (c Currency) Decimals() int {
+	switch {
+	case c == "":
+		return unknownDecimals
+	case zeroDecimalCurrencies[c]:
+		return 0
+	case threeDecimalCurrencies[c]:
+		return 3
+	}
+	return 2
+}
+
+// NewMoney is minor units of c.
+func NewMoney(minor int64, c Currency) Money {
+	return Money{minor: minor, currency: c}
+}
+
+// ParseMoney reads a decimal string such as "12.5" or "-0.07" as an amount
+// of c. Digits past c's minor unit are rounded half away from zero. With an
+// empty c the amount has no currency yet.
+func ParseMoney(s string, c Currency) (Money, error) {
+	v, err := parseDecimal(s, c.Decimals())
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid amount %q", s)
+	}
+	return Money{minor: v, currency: c}, nil
+}
+
+// parseDecimal reads s as an integer count of 10^-places units, rounding
+// the digits past places half away from zero. It takes at most one leading
+// sign and needs at least one digit.
+func parseDecimal(s string, places int) (int64, error) {
+	s = strings.TrimSpace(s)
+	neg := false
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" {
+		return 0, strconv.ErrSyntax
+	}
+	for _, d := range whole + frac {
+		if d < '0' || d > '9' {
+			return 0, strconv.ErrSyntax
+		}
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	var minor, scale int64 = 0, 1
+	for i := 0; i < places; i++ {
+		minor *= 10
+		scale *= 10
+		if i < len(frac) {
+			minor += int64(frac[i] - '0')
+		}
+	}
+	if len(frac) > places && frac[places] >= '5' {
+		minor++
+	}
+	if units > (math.MaxInt64-minor)/scale {
+		return 0, strconv.ErrRange
+	}
+	v := units*scale + minor
+	if neg {
+		v = -v
+	}
+	return v, nil
+}
+
+// Currency is the currency of the amount, empty when it has none yet.
+func (m Money) Currency() Currency {
+	return m.currency
+}
+
+// Minor is the amount in minor units of its currency, as payment providers
+// take it.
+func (m Money) Minor() int64 {
+	return m.minor
+}
+
+func (m Money) String() string {
+	places := m.currency.Decimals()
+	sign := ""
+	v := m.minor
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	scale := pow10(places)
+	if places == 0 {
+		return fmt.Sprintf("%s%d", sign, v)
+	}
+	frac := fmt.Sprintf("%0*d", places, v%scale)
+	if m.currency == "" {
+		// no currency to say how many places, so show cents unless
+		// there is more
+		frac = strings.TrimRight(frac, "0")
+		for len(frac) < 2 {
+			frac += "0"
+		}
+	}
+	return fmt.Sprintf("%s%d.%s", sign, v/scale, frac)
+}
+
+// Round gives the amount the currency c, rounding half away from zero to
+// c's minor unit. An amount already in c, or an empty c, leaves it as it
+// is; an amount in another currency panics, as that needs Convert.
+func (m Money) Round(c Currency) Money {
+	if m.currency == c || c == "" {
+		return m
+	}
+	if m.currency != "" {
+		panic(fmt.Sprintf("money: %s amount used as %s", m.currency, c))
+	}
+	return Money{minor: rescale(m.minor, unknownDecimals, c.Decimals()), currency: c}
+}
+
+// rescale turns a count of 10^-from units into 10^-to units, rounding half
+// away from zero.
+func rescale(v int64, from, to int) int64 {
+	if to >= from {
+		return v * pow10(to-from)
+	}
+	return divRound(v, pow10(from-to))
+}
+
+// align puts both amounts in the same currency, giving one without a
+// currency the other's.
+func (m Money) align(o Money) (Money, Money) {
+	if m.currency == "" {
+		return m.Round(o.currency), o
+	}
+	return m, o.Round(m.currency)
+}
+
+func (m Money) Add(o Money) Money {
+	m, o = m.align(o)
+	return Money{minor: m.minor + o.minor, currency: m.currency}
+}
+
+func (m Money) Sub(o Money) Money {
+	m, o = m.align(o)
+	return Money{minor: m.minor - o.minor, currency: m.currency}
+}
+
+func (m Money) Neg() Money {
+	return Money{minor: -m.minor, currency: m.currency}
+}
+
+// Cmp compares the amounts, returning -1, 0 or +1.
+func (m Money) Cmp(o Money) int {
+	m, o = m.align(o)
+	switch {
+	case m.minor < o.minor:
+		return -1
+	case m.minor > o.minor:
+		return 1
+	}
+	return 0
+}
+
+// Min is the smaller of the two amounts.
+func (m Money) Min(o Money) Money {
+	m, o = m.align(o)
+	if o.minor < m.minor {
+		return o
+	}
+	return m
+}
+
+// Sign is -1, 0 or +1 as the amount is negative, zero or positive.
+func (m Money) Sign() int {
+	switch {
+	case m.minor < 0:
+		return -1
+	case m.minor > 0:
+		return 1
+	}
+	return 0
+}
+
+func (m Money) IsZero() bool {
+	return m.minor == 0
+}
+
+// Mul multiplies by a whole quantity.
+func (m Money) Mul(qty int) Money {
+	return Money{minor: m.minor * int64(qty), currency: m.currency}
+}
+
+// Percent applies a rate given in basis points (1/100 of a percent),
+// rounding half away from zero to the minor unit.
+func (m Money) Percent(basisPoints int64) Money {
+	return Money{minor: divRound(m.minor*basisPoints, 10000), currency: m.currency}
+}
+
+// Share is the amount times num/den, rounded half away from zero to the
+// minor unit. It splits discounts and tax over lines and quantities.
+func (m Money) Share(num, den int64) Money {
+	n := new(big.Int).Mul(big.NewInt(m.minor), big.NewInt(num))
+	return Money{minor: bigDivRound(n, big.NewInt(den)), currency: m.currency}
+}
+
+// Prorate is the part of the amount that part of whole carries, the amount
+// times part/whole. part and whole are in the same currency; the result is
+// in the amount's.
+func (m Money) Prorate(part, whole Money) Money {
+	part, whole = part.align(whole)
+	return m.Share(part.minor, whole.minor)
+}
+
+func divRound(n, d int64) int64 {
+	q, r := n/d, n%d
+	if 2*abs(r) >= abs(d) {
+		if (n < 0) != (d < 0) {
+			q--
+		} else {
+			q++
+		}
+	}
+	return q
+}
+
+func bigDivRound(n, d *big.Int) int64 {
+	q, r := new(big.Int).QuoRem(n, d, new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(r), big.NewInt(2)).Cmp(new(big.Int).Abs(d)) >= 0 {
+		if n.Sign()*d.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q.Int64()
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func pow10(n int) int64 {
+	v := int64(1)
+	for ; n > 0; n-- {
+		v *= 10
+	}
+	return v
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON reads a bare decimal, which has no currency until Round
+// gives it one.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "null" {
+		return nil
+	}
+	v, err := ParseMoney(s, "")
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// Scan reads a NUMERIC column. The amount has no currency until Round gives
+// it one.
+func (m *Money) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*m = Money{}
+		return nil
+	case []byte:
+		parsed, err := ParseMoney(string(v), "")
+		*m = parsed
+		return err
+	case string:
+		parsed, err := ParseMoney(v, "")
+		*m = parsed
+		return err
+	case int64:
+		*m = Money{minor: v * pow10(unknownDecimals)}
+		return nil
+	case float64:
+		*m = Money{minor: int64(math.Round(v * float64(pow10(unknownDecimals))))}
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+}
+
+// Value writes the amount as a decimal string so NUMERIC columns store it
+// exactly.
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// Convert turns the amount into currency c at rate r, rounding half away
+// from zero to c's minor unit.
+func (m Money) Convert(r Rate, c Currency) Money {
+	n := new(big.Int).Mul(big.NewInt(m.minor), big.NewInt(int64(r)))
+	d := big.NewInt(rateScale)
+	if shift := c.Decimals() - m.currency.Decimals(); shift >= 0 {
+		n.Mul(n, big.NewInt(pow10(shift)))
+	} else {
+		d.Mul(d, big.NewInt(pow10(-shift)))
+	}
+	return Money{minor: bigDivRound(n, d), currency: c}
+}
+
+// Rate is an exchange rate in units of 10^-8: how much of a currency one
+// unit of the base currency buys. It encodes to JSON and NUMERIC(18,8) as a
+// decimal, like Money.
+type Rate int64
+
+const (
+	ratePlaces = 8
+	rateScale  = 100000000
+)
+
+// One is the rate of the base currency to itself.
+const One Rate = rateScale
+
+func ParseRate(s string) (Rate, error) {
+	v, err := parseDecimal(s, ratePlaces)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return Rate(v), nil
+}
+
+// String prints the rate without trailing zeros, e.g. "0.9215".
+func (r Rate) String() string {
+	sign := ""
+	v := int64(r)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	frac := strings.TrimRight(fmt.Sprintf("%08d", v%rateScale), "0")
+	if frac == "" {
+		return fmt.Sprintf("%s%d", sign, v/rateScale)
+	}
+	return fmt.Sprintf("%s%d.%s", sign, v/rateScale, frac)
+}
+
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "null" {
+		return nil
+	}
+	v, err := ParseRate(s)
+	if err != nil {
+		return err
+	}
+	*r = v
+	return nil
+}
+
+func (r *Rate) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*r = 0
+		return nil
+	case []byte:
+		parsed, err := ParseRate(string(v))
+		*r = parsed
+		return err
+	case string:
+		parsed, err := ParseRate(v)
+		*r = parsed
+		return err
+	case int64:
+		*r = Rate(v * rateScale)
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into Rate", src)
+	}
+}
+
+func (r Rate) Value() (driver.Value, error) {
+	return r.String(), nil
+}
@@ -0,0 +1,154 @@
+package structTypes
+
+import "testing"
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in       string
+		currency Currency
+		want     int64
+		wantErr  bool
+	}{
+		{in: "12.5", currency: "USD", want: 1250},
+		{in: "-0.07", currency: "USD", want: -7},
+		{in: "+3", currency: "USD", want: 300},
+		{in: " 1.005 ", currency: "USD", want: 101},
+		{in: "-1.005", currency: "USD", want: -101},
+		{in: "1.004", currency: "USD", want: 100},
+		{in: ".5", currency: "USD", want: 50},
+		{in: "7.", currency: "USD", want: 700},
+		{in: "1999.5", currency: "JPY", want: 2000},
+		{in: "1999.4", currency: "JPY", want: 1999},
+		{in: "1.2345", currency: "KWD", want: 1235},
+		{in: "19.99", currency: "", want: 19990},
+		{in: "", currency: "USD", wantErr: true},
+		{in: ".", currency: "USD", wantErr: true},
+		{in: "-", currency: "USD", wantErr: true},
+		{in: "+-5", currency: "USD", wantErr: true},
+		{in: "--5", currency: "USD", wantErr: true},
+		{in: "-+5", currency: "USD", wantErr: true},
+		{in: "1e3", currency: "USD", wantErr: true},
+		{in: "1.2.3", currency: "USD", wantErr: true},
+		{in: "abc", currency: "USD", wantErr: true},
+		{in: "92233720368547759", currency: "USD", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in, tt.currency)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseMoney(%q, %q) = %v, want error", tt.in, tt.currency, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseMoney(%q, %q): %v", tt.in, tt.currency, err)
+			continue
+		}
+		if got.Minor() != tt.want || got.Currency() != tt.currency {
+			t.Errorf("ParseMoney(%q, %q) = %d %s, want %d %s", tt.in, tt.currency, got.Minor(), got.Currency(), tt.want, tt.currency)
+		}
+	}
+}
+
+func TestDivRound(t *testing.T) {
+	tests := []struct {
+		n, d, want int64
+	}{
+		{10, 2, 5},
+		{5, 2, 3},
+		{-5, 2, -3},
+		{5, -2, -3},
+		{-5, -2, 3},
+		{4, 3, 1},
+		{-4, 3, -1},
+		{149, 100, 1},
+		{150, 100, 2},
+		{-150, 100, -2},
+		{0, 7, 0},
+	}
+	for _, tt := range tests {
+		if got := divRound(tt.n, tt.d); got != tt.want {
+			t.Errorf("divRound(%d, %d) = %d, want %d", tt.n, tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestPercent(t *testing.T) {
+	tests := []struct {
+		amount      Money
+		basisPoints int64
+		want        Money
+	}{
+		{NewMoney(1000, "USD"), 1000, NewMoney(100, "USD")},
+		{NewMoney(1999, "USD"), 825, NewMoney(165, "USD")},
+		{NewMoney(50, "USD"), 1000, NewMoney(5, "USD")},
+		{NewMoney(5, "USD"), 1000, NewMoney(1, "USD")},
+		{NewMoney(-5, "USD"), 1000, NewMoney(-1, "USD")},
+		{NewMoney(1999, "JPY"), 1000, NewMoney(200, "JPY")},
+		{NewMoney(12345, "KWD"), 500, NewMoney(617, "KWD")},
+		{NewMoney(1000, "USD"), 0, NewMoney(0, "USD")},
+	}
+	for _, tt := range tests {
+		if got := tt.amount.Percent(tt.basisPoints); got != tt.want {
+			t.Errorf("%s %s.Percent(%d) = %s, want %s", tt.amount, tt.amount.Currency(), tt.basisPoints, got, tt.want)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		amount Money
+		rate   string
+		to     Currency
+		want   Money
+	}{
+		{NewMoney(1999, "USD"), "0.9215", "EUR", NewMoney(1842, "EUR")},
+		{NewMoney(1999, "USD"), "149.5", "JPY", NewMoney(2989, "JPY")},
+		{NewMoney(1999, "USD"), "0.3075", "KWD", NewMoney(6147, "KWD")},
+		{NewMoney(2989, "JPY"), "0.00668896", "USD", NewMoney(1999, "USD")},
+		{NewMoney(6147, "KWD"), "3.25203252", "USD", NewMoney(1999, "USD")},
+		{NewMoney(-1999, "USD"), "0.9215", "EUR", NewMoney(-1842, "EUR")},
+		{NewMoney(0, "USD"), "149.5", "JPY", NewMoney(0, "JPY")},
+	}
+	for _, tt := range tests {
+		rate, err := ParseRate(tt.rate)
+		if err != nil {
+			t.Fatalf("ParseRate(%q): %v", tt.rate, err)
+		}
+		if got := tt.amount.Convert(rate, tt.to); got != tt.want {
+			t.Errorf("%s %s at %s = %s %s, want %s %s", tt.amount, tt.amount.Currency(), tt.rate, got, got.Currency(), tt.want, tt.to)
+		}
+	}
+}
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		amount Money
+		to     Currency
+		want   Money
+	}{
+		{NewMoney(19990, ""), "USD", NewMoney(1999, "USD")},
+		{NewMoney(19995, ""), "USD", NewMoney(2000, "USD")},
+		{NewMoney(-19995, ""), "USD", NewMoney(-2000, "USD")},
+		{NewMoney(19994, ""), "USD", NewMoney(1999, "USD")},
+		{NewMoney(1999500, ""), "JPY", NewMoney(2000, "JPY")},
+		{NewMoney(19995, ""), "KWD", NewMoney(19995, "KWD")},
+		{NewMoney(1999, "USD"), "USD", NewMoney(1999, "USD")},
+		{NewMoney(1999, "USD"), "", NewMoney(1999, "USD")},
+		{NewMoney(19995, ""), "", NewMoney(19995, "")},
+	}
+	for _, tt := range tests {
+		if got := tt.amount.Round(tt.to); got != tt.want {
+			t.Errorf("%s %q.Round(%q) = %s %s, want %s %s", tt.amount, tt.amount.Currency(), tt.to, got, got.Currency(), tt.want, tt.to)
+		}
+	}
+}
+
+func TestRoundOtherCurrencyPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Round to another currency didn't panic")
+		}
+	}()
+	NewMoney(1999, "USD").Round("EUR")
+}
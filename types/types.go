@@ -1,6 +1,7 @@
 package structTypes
 
 import (
+	"context"
 	"net/http"
 	"time"
 )
@@ -11,27 +12,111 @@ type APIServer struct {
 }
 
 type Storage interface {
-	GetData()
-	CreateUser(*UserAccount) error
-	GetAllProducts() ([]Product, error)
-	GetProductByID(int) (Product, error)
-	GetCartByID(int) ([]CartProduct, error)
-	AddToCart(int, int, int) error
-	EmptyCart(int) error
-	DeleteFromCart(int, int) error
-	GetAllOrdersByUserID(int) ([]OrderResponse, error)
-	GetOrderByID(int) (OrderResponse, error)
-	CreateOrder(int, []OrderRequest) error
-	UpdateOrderStatus(int, string) error
-	DeleteOrder(int) error
-	CreateNewReview(ReviewRequest) error
-	GetAllReviewsByProductID(int) ([]ReviewResponse, error)
+	GetData(context.Context) ([]UserAccount, error)
+	CreateUser(context.Context, *UserAccount) error
+	GetAllProducts(context.Context, ProductFilter) ([]Product, error)
+	GetProductByID(context.Context, int) (Product, error)
+	GetUserByUsername(context.Context, string) (UserAccount, error)
+	CartIDForUser(context.Context, int) (int, error)
+	CartIDForToken(context.Context, string) (int, error)
+	CreateGuestCart(context.Context) (int, string, error)
+	MergeGuestCart(context.Context, string, int, CartMergeStrategy) error
+	GetCartByID(context.Context, int) ([]CartProduct, error)
+	AddToCart(context.Context, int, int, int) (int, error)
+	SetCartItemQuantity(context.Context, int, int, int) (int, error)
+	EmptyCart(context.Context, int) error
+	DeleteFromCart(context.Context, int, int) error
+	GetWishlistsByUserID(context.Context, int) ([]Wishlist, error)
+	CreateWishlist(context.Context, int, string, bool) (Wishlist, error)
+	UpdateWishlist(context.Context, int, int, string, bool) (Wishlist, error)
+	DeleteWishlist(context.Context, int, int) error
+	GetWishlist(context.Context, int, int) (Wishlist, error)
+	GetSharedWishlist(context.Context, string) (Wishlist, error)
+	AddToWishlist(context.Context, int, int, int, int) error
+	RemoveFromWishlist(context.Context, int, int, int) error
+	MoveWishlistItemToCart(context.Context, int, int, int) (int, error)
+	SaveForLater(context.Context, int, int, int) (int, error)
+	CreatePromotion(context.Context, Promotion) (Promotion, error)
+	GetPromotions(context.Context) ([]Promotion, error)
+	SetPromotionActive(context.Context, int, bool) error
+	GetPromotionByCode(context.Context, string) (Promotion, error)
+	GetPromotionUsage(context.Context, int, int) (int, int, error)
+	GetCartPromotion(context.Context, int) (*Promotion, error)
+	ApplyCartPromotion(context.Context, int, int) error
+	RemoveCartPromotion(context.Context, int) error
+	GetAddresses(context.Context, int) ([]Address, error)
+	GetAddress(context.Context, int, int) (Address, error)
+	CreateAddress(context.Context, int, Address) (Address, error)
+	UpdateAddress(context.Context, int, int, Address) (Address, error)
+	DeleteAddress(context.Context, int, int) error
+	CreateShippingMethod(context.Context, ShippingMethod) (ShippingMethod, error)
+	GetShippingMethods(context.Context, bool) ([]ShippingMethod, error)
+	SetShippingMethodActive(context.Context, int, bool) error
+	CreateShipment(context.Context, int, string, string) (Shipment, error)
+	MarkShipmentDelivered(context.Context, int) (Shipment, error)
+	GetTaxRates(context.Context) ([]TaxRate, error)
+	PutTaxRate(context.Context, TaxRate) (TaxRate, error)
+	DeleteTaxRate(context.Context, int) error
+	GetExchangeRates(context.Context) ([]ExchangeRate, error)
+	GetExchangeRate(context.Context, Currency) (ExchangeRate, error)
+	PutExchangeRates(context.Context, []ExchangeRate) error
+	DeleteExchangeRate(context.Context, Currency) error
+	GetPriceList(context.Context, Currency) (map[int]Money, error)
+	GetProductPrices(context.Context, int) ([]ProductPrice, error)
+	PutProductPrice(context.Context, ProductPrice) (ProductPrice, error)
+	DeleteProductPrice(context.Context, int, Currency) error
+	GetAllOrdersByUserID(context.Context, int) ([]OrderResponse, error)
+	GetOrderByID(context.Context, int) (OrderResponse, error)
+	CreateOrder(context.Context, int, []OrderRequest, OrderOptions) (int, error)
+	EstimateCheckout(context.Context, int, []CartProduct, *AppliedPromotion, OrderOptions) (CheckoutEstimate, error)
+	UpdateOrderStatus(context.Context, int, string) error
+	CancelOrder(context.Context, int, string, string) error
+	CreateReturn(context.Context, int, int, ReturnRequest, time.Time) (Return, error)
+	GetReturnsByOrderID(context.Context, int) ([]Return, error)
+	GetReturns(context.Context, string) ([]Return, error)
+	GetReturnByID(context.Context, int) (Return, error)
+	DecideReturn(context.Context, int, bool, string, string) (Return, error)
+	ReceiveReturn(context.Context, int, map[int]string) (Return, error)
+	MarkReturnRefunded(context.Context, int, Money) (Return, error)
+	ApplyPaymentEvent(context.Context, string, PaymentEvent) (Payment, error)
+	GetPayment(context.Context, string, string) (Payment, error)
+	GetPaymentsByOrderID(context.Context, int) ([]Payment, error)
+	CreateNewReview(context.Context, ReviewRequest) (bool, error)
+	UpdateReview(context.Context, ReviewRequest) error
+	DeleteReview(context.Context, int, int) error
+	GetReviewsForModeration(context.Context, string) ([]ReviewResponse, error)
+	ModerateReview(context.Context, int, bool, string) (ReviewResponse, error)
+	ReportReview(context.Context, int, int, string, int) error
+	VoteReview(context.Context, int, int, bool) (ReviewResponse, error)
+	RemoveReviewVote(context.Context, int, int) (ReviewResponse, error)
+	GetAllReviewsByProductID(context.Context, ReviewQuery) (ProductReviews, error)
+	RecordAbandonedCarts(context.Context, time.Time) ([]CartAbandonment, error)
+	GetPendingCartReminders(context.Context, int) ([]CartReminder, error)
+	MarkCartReminderSent(context.Context, int) error
+	GetAbandonmentReport(context.Context, time.Time) (AbandonmentReport, error)
+	BeginIdempotentRequest(context.Context, string, string, time.Time) (IdempotencyRecord, bool, error)
+	CompleteIdempotentRequest(context.Context, string, int, http.Header, []byte) error
+	ReleaseIdempotentRequest(context.Context, string) error
+	PurgeIdempotencyKeys(context.Context, time.Time) (int, error)
+	Ping(context.Context) error
+	MigrationsApplied() bool
 }
 
 type ErrorMSG struct {
 	Error string `json:"error"`
 }
 
+type HealthCheck struct {
+	Status    string `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	LatencyMS int64  `json:"latency_ms,omitempty"`
+}
+
+type HealthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
 type UserAccount struct {
 	ID            int       `json:"id"`
 	Username      string    `json:"username"`
@@ -43,64 +128,551 @@ type UserAccount struct {
 type ApiFunc func(http.ResponseWriter, *http.Request) error
 
 type Product struct {
-	ID          int       `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Price       float64   `json:"price"`
-	Stock       int       `json:"stock"`
-	Created_at  time.Time `json:"created_at"`
+	ID           int       `json:"id"`
+	Name         string    `json:"name"`
+	Description  string    `json:"description"`
+	Price        Money     `json:"price"`
+	Currency     Currency  `json:"currency,omitempty"`
+	Stock        int       `json:"stock"`
+	Category     string    `json:"category,omitempty"`
+	MaxPerOrder  *int      `json:"max_per_order,omitempty"`
+	Discontinued bool      `json:"discontinued"`
+	WeightGrams  int       `json:"weight_grams"`
+	TaxClass     string    `json:"tax_class,omitempty"`
+	Rating       Rating    `json:"rating"`
+	Created_at   time.Time `json:"created_at"`
+}
+
+// Rating summarises a product's approved reviews. Histogram[i] counts the
+// reviews giving i+1 stars.
+type Rating struct {
+	Average   float64 `json:"average"`
+	Count     int     `json:"count"`
+	Histogram [5]int  `json:"histogram"`
+}
+
+// ProductFilter narrows and orders the product list. Sort is one of id,
+// price, newest, rating or reviews; MinRating drops products whose average
+// rating is lower.
+type ProductFilter struct {
+	Sort      string
+	Desc      bool
+	MinRating float64
 }
 
 type CartProduct struct {
-	CartItemID         int     `json:"cart_item_id"`
-	ProductID          int     `json:"product_id"`
-	ProductName        string  `json:"product_name"`
-	ProductDescription string  `json:"product_description"`
-	Quantity           int     `json:"quantity"`
-	Price              float64 `json:"price_at_time"`
-	TotalPrice         float64 `json:"total_price"`
+	CartItemID         int    `json:"cart_item_id"`
+	ProductID          int    `json:"product_id"`
+	ProductName        string `json:"product_name"`
+	ProductDescription string `json:"product_description"`
+	Category           string `json:"category,omitempty"`
+	Quantity           int    `json:"quantity"`
+	WeightGrams        int    `json:"weight_grams"`
+	Price              Money  `json:"price_at_time"`
+	TotalPrice         Money  `json:"total_price"`
+	CurrentPrice       Money  `json:"current_price"`
+	PriceChanged       bool   `json:"price_changed"`
+	AvailableStock     int    `json:"available_stock"`
+	Availability       string `json:"availability"`
+	Warning            string `json:"warning,omitempty"`
+}
+
+const (
+	AvailabilityInStock      = "in_stock"
+	AvailabilityReducedStock = "reduced_stock"
+	AvailabilityOutOfStock   = "out_of_stock"
+	AvailabilityDiscontinued = "discontinued"
+)
+
+// CartMergeStrategy decides the quantity of a product found in both the
+// guest cart and the user cart when they are merged on sign in.
+type CartMergeStrategy string
+
+const (
+	MergeSum       CartMergeStrategy = "sum"
+	MergeMax       CartMergeStrategy = "max"
+	MergeKeepUser  CartMergeStrategy = "keep_user"
+	MergeKeepGuest CartMergeStrategy = "keep_guest"
+)
+
+type CartSummary struct {
+	Items            []CartProduct     `json:"items"`
+	Promotion        *AppliedPromotion `json:"promotion,omitempty"`
+	PromotionWarning string            `json:"promotion_warning,omitempty"`
+	ItemCount        int               `json:"item_count"`
+	Subtotal         Money             `json:"subtotal"`
+	Discount         Money             `json:"discount"`
+	EstimatedTax     Money             `json:"estimated_tax"`
+	ShippingEstimate Money             `json:"shipping_estimate"`
+	GrandTotal       Money             `json:"grand_total"`
+	PricesIncludeTax bool              `json:"prices_include_tax"`
+	ShippingMethod   string            `json:"shipping_method,omitempty"`
+	EstimateWarning  string            `json:"estimate_warning,omitempty"`
+	Currency         Currency          `json:"currency"`
+}
+
+// CheckoutEstimate is the shipping and tax checkout would charge for a
+// cart. Warning says why they were left out, when they were.
+type CheckoutEstimate struct {
+	ShippingMethod string
+	Shipping       Money
+	Tax            Money
+	TaxInclusive   bool
+	Warning        string
+}
+
+type Wishlist struct {
+	ID           int            `json:"id"`
+	UserID       int            `json:"user_id"`
+	Name         string         `json:"name"`
+	Public       bool           `json:"public"`
+	ShareToken   string         `json:"share_token,omitempty"`
+	SaveForLater bool           `json:"save_for_later"`
+	CreatedAt    time.Time      `json:"created_at"`
+	Items        []WishlistItem `json:"items,omitempty"`
+}
+
+type WishlistItem struct {
+	ID                 int       `json:"id"`
+	ProductID          int       `json:"product_id"`
+	ProductName        string    `json:"product_name"`
+	ProductDescription string    `json:"product_description"`
+	Price              Money     `json:"price"`
+	Quantity           int       `json:"quantity"`
+	InStock            bool      `json:"in_stock"`
+	AddedAt            time.Time `json:"added_at"`
+}
+
+type PromotionKind string
+
+const (
+	PromotionPercentage   PromotionKind = "percentage"
+	PromotionFixed        PromotionKind = "fixed"
+	PromotionFreeShipping PromotionKind = "free_shipping"
+	PromotionBuyXGetY     PromotionKind = "buy_x_get_y"
+)
+
+type Promotion struct {
+	ID                 int           `json:"id"`
+	Code               string        `json:"code"`
+	Description        string        `json:"description"`
+	Kind               PromotionKind `json:"kind"`
+	PercentBasisPoints int64         `json:"percent_bps,omitempty"`
+	AmountOff          Money         `json:"amount_off,omitzero"`
+	BuyQuantity        int           `json:"buy_quantity,omitempty"`
+	GetQuantity        int           `json:"get_quantity,omitempty"`
+	MinOrder           Money         `json:"min_order"`
+	MaxUses            *int          `json:"max_uses"`
+	MaxUsesPerUser     *int          `json:"max_uses_per_user"`
+	StartsAt           *time.Time    `json:"starts_at"`
+	EndsAt             *time.Time    `json:"ends_at"`
+	ProductIDs         []int         `json:"product_ids"`
+	Categories         []string      `json:"categories"`
+	Active             bool          `json:"active"`
+	CreatedAt          time.Time     `json:"created_at"`
+}
+
+type AppliedPromotion struct {
+	Code         string `json:"code"`
+	Description  string `json:"description,omitempty"`
+	Discount     Money  `json:"discount"`
+	FreeShipping bool   `json:"free_shipping"`
+}
+
+// Address is an entry of a user's address book. Orders keep a copy of the
+// address they ship and bill to, so editing the book doesn't change them.
+type Address struct {
+	ID              int       `json:"id"`
+	Label           string    `json:"label,omitempty"`
+	Name            string    `json:"name"`
+	Line1           string    `json:"line1"`
+	Line2           string    `json:"line2,omitempty"`
+	City            string    `json:"city"`
+	Region          string    `json:"region,omitempty"`
+	PostalCode      string    `json:"postal_code,omitempty"`
+	Country         string    `json:"country"`
+	Phone           string    `json:"phone,omitempty"`
+	DefaultShipping bool      `json:"default_shipping,omitempty"`
+	DefaultBilling  bool      `json:"default_billing,omitempty"`
+	CreatedAt       time.Time `json:"created_at,omitzero"`
+}
+
+// OrderOptions are the checkout choices an order is placed with. Zero
+// address IDs fall back to the user's default addresses, and an empty
+// shipping method to the cheapest one that ships to the address.
+type OrderOptions struct {
+	ShippingAddressID int    `json:"shipping_address_id"`
+	BillingAddressID  int    `json:"billing_address_id"`
+	ShippingMethod    string `json:"shipping_method"`
+	// Currency is the currency the order is charged in, the base currency
+	// when empty.
+	Currency Currency `json:"currency"`
+}
+
+// ShippingRate prices shipping to a zone. A rate without countries covers
+// every country no other rate of the method lists. Weight bounds are
+// inclusive; a zero maximum means no upper bound.
+type ShippingRate struct {
+	Countries      []string `json:"countries,omitempty"`
+	MinWeightGrams int      `json:"min_weight_grams,omitempty"`
+	MaxWeightGrams int      `json:"max_weight_grams,omitempty"`
+	Base           Money    `json:"base"`
+	PerKg          Money    `json:"per_kg,omitzero"`
+}
+
+// ShippingMethod is a way of shipping orders. Shipping is free once the
+// order value reaches FreeOver, when it is set.
+type ShippingMethod struct {
+	ID            int            `json:"id"`
+	Code          string         `json:"code"`
+	Name          string         `json:"name"`
+	EstimatedDays int            `json:"estimated_days,omitempty"`
+	FreeOver      Money          `json:"free_over,omitzero"`
+	Rates         []ShippingRate `json:"rates"`
+	Active        bool           `json:"active"`
+	CreatedAt     time.Time      `json:"created_at,omitzero"`
+}
+
+type ShippingQuote struct {
+	Method        string `json:"method"`
+	Name          string `json:"name"`
+	Cost          Money  `json:"cost"`
+	EstimatedDays int    `json:"estimated_days,omitempty"`
+}
+
+// TaxRate is the rate charged on a tax class in a jurisdiction. An empty
+// region covers the whole country and an empty class is the standard rate.
+type TaxRate struct {
+	ID              int       `json:"id"`
+	Country         string    `json:"country"`
+	Region          string    `json:"region,omitempty"`
+	TaxClass        string    `json:"tax_class,omitempty"`
+	RateBasisPoints int64     `json:"rate_bps"`
+	CreatedAt       time.Time `json:"created_at,omitzero"`
+}
+
+// ExchangeRate is how much of Currency one unit of the base currency buys.
+type ExchangeRate struct {
+	Currency  Currency  `json:"currency"`
+	Rate      Rate      `json:"rate"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+}
+
+// ProductPrice fixes a product's price in a currency instead of converting
+// it from the base price.
+type ProductPrice struct {
+	ProductID int       `json:"product_id"`
+	Currency  Currency  `json:"currency"`
+	Price     Money     `json:"price"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+}
+
+const (
+	ShipmentShipped   = "shipped"
+	ShipmentDelivered = "delivered"
+)
+
+type Shipment struct {
+	ID             int        `json:"id"`
+	OrderID        int        `json:"order_id"`
+	Carrier        string     `json:"carrier"`
+	TrackingNumber string     `json:"tracking_number"`
+	Status         string     `json:"status"`
+	ShippedAt      time.Time  `json:"shipped_at"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+}
+
+// Order statuses. Payment outcomes move an order between them.
+const (
+	OrderPending           = "pending"
+	OrderAuthorized        = "authorized"
+	OrderPaid              = "paid"
+	OrderPaymentFailed     = "payment_failed"
+	OrderCancelled         = "cancelled"
+	OrderShipped           = "shipped"
+	OrderDelivered         = "delivered"
+	OrderPartiallyRefunded = "partially_refunded"
+	OrderRefunded          = "refunded"
+)
+
+// OrderTransitions are the status changes the shop may make by hand. Every
+// other change follows from payments, shipments, cancellation and returns.
+var OrderTransitions = map[string][]string{
+	OrderPaid:    {OrderShipped},
+	OrderShipped: {OrderDelivered},
+}
+
+// CanTransitionOrder reports whether an order in status from may be moved
+// to status to by hand.
+func CanTransitionOrder(from, to string) bool {
+	for _, next := range OrderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// OrderCancellable reports whether an order in status can still be
+// cancelled: it hasn't shipped and its stock is still reserved. A paid
+// order's payment has to be refunded first.
+func OrderCancellable(status string) bool {
+	switch status {
+	case OrderPending, OrderAuthorized, OrderPaid, OrderRefunded:
+		return true
+	}
+	return false
 }
 
 type Order struct {
 	ID        int       `json:"id"`
 	UserID    int       `json:"user_id"`
-	ProductID int       `json:"product_id"`
-	Quantity  int       `json:"quantity"`
-	Price     float64   `json:"price"`
+	Total     Money     `json:"total"`
+	Currency  Currency  `json:"currency"`
 	Status    string    `json:"status"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// OrderRequest is one line of a new order. Lines are always charged the
+// product's current price.
 type OrderRequest struct {
-	ProductID int     `json:"product_id"`
-	Quantity  int     `json:"quantity"`
-	Price     float64 `json:"price"`
+	ProductID int `json:"product_id"`
+	Quantity  int `json:"quantity"`
+}
+
+type OrderItemResponse struct {
+	ID          int    `json:"id"`
+	ProductID   int    `json:"product_id"`
+	ProductName string `json:"product_name"`
+	Description string `json:"description"`
+	Quantity    int    `json:"quantity"`
+	Price       Money  `json:"price"`
+	TaxAmount   Money  `json:"tax_amount"`
+	TaxRate     int64  `json:"tax_rate_bps"`
 }
 
 type OrderResponse struct {
-	ID          int       `json:"id"`
-	UserID      int       `json:"user_id"`
-	ProductID   int       `json:"product_id"`
-	ProductName string    `json:"product_name"`
-	Description string    `json:"description"`
-	Quantity    int       `json:"quantity"`
-	Price       float64   `json:"price"`
-	Status      string    `json:"status"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID             int                 `json:"id"`
+	UserID         int                 `json:"user_id"`
+	Discount       Money               `json:"discount"`
+	PromotionCode  string              `json:"promotion_code,omitempty"`
+	ShippingMethod string              `json:"shipping_method,omitempty"`
+	ShippingCost   Money               `json:"shipping_cost"`
+	Tax            Money               `json:"tax"`
+	TaxIncluded    bool                `json:"prices_include_tax"`
+	Total          Money               `json:"total"`
+	Currency       Currency            `json:"currency"`
+	ExchangeRate   Rate                `json:"exchange_rate"`
+	Status         string              `json:"status"`
+	CreatedAt      time.Time           `json:"created_at"`
+	CancelledAt    *time.Time          `json:"cancelled_at,omitempty"`
+	CancelReason   string              `json:"cancel_reason,omitempty"`
+	CancelledBy    string              `json:"cancelled_by,omitempty"`
+	ShippingTo     *Address            `json:"shipping_address,omitempty"`
+	BillingTo      *Address            `json:"billing_address,omitempty"`
+	Shipments      []Shipment          `json:"shipments,omitempty"`
+	Items          []OrderItemResponse `json:"items"`
+}
+
+type PaymentStatus string
+
+const (
+	PaymentAuthorized PaymentStatus = "authorized"
+	PaymentCaptured   PaymentStatus = "captured"
+	PaymentRefunded   PaymentStatus = "refunded"
+	PaymentVoided     PaymentStatus = "voided"
+	PaymentFailed     PaymentStatus = "failed"
+)
+
+type Payment struct {
+	ID             int           `json:"id"`
+	OrderID        int           `json:"order_id"`
+	Provider       string        `json:"provider"`
+	Reference      string        `json:"reference"`
+	Status         PaymentStatus `json:"status"`
+	Amount         Money         `json:"amount"`
+	CapturedAmount Money         `json:"captured_amount"`
+	RefundedAmount Money         `json:"refunded_amount"`
+	Currency       Currency      `json:"currency"`
+	FailureReason  string        `json:"failure_reason,omitempty"`
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+}
+
+// PaymentEvent reports one outcome at the payment provider, either as the
+// provider's direct answer or as a webhook delivery. Amount is the amount
+// the event moved; for refunds that is the amount refunded by this event.
+type PaymentEvent struct {
+	ID        string        `json:"id"`
+	Status    PaymentStatus `json:"status"`
+	Reference string        `json:"reference"`
+	OrderID   int           `json:"order_id"`
+	Amount    Money         `json:"amount"`
+	Reason    string        `json:"reason,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+// Return statuses, in workflow order. A rejected return stops there.
+const (
+	ReturnRequested = "requested"
+	ReturnApproved  = "approved"
+	ReturnRejected  = "rejected"
+	ReturnReceived  = "received"
+	ReturnRefunded  = "refunded"
+)
+
+// Conditions a returned item can be received in. Only resellable items go
+// back in stock.
+const (
+	ConditionResellable = "resellable"
+	ConditionDamaged    = "damaged"
+)
+
+type ReturnItemRequest struct {
+	OrderItemID int    `json:"order_item_id"`
+	Quantity    int    `json:"quantity"`
+	Reason      string `json:"reason"`
 }
 
+type ReturnRequest struct {
+	Reason string              `json:"reason"`
+	Items  []ReturnItemRequest `json:"items"`
+}
+
+type ReturnItem struct {
+	ID           int    `json:"id"`
+	OrderItemID  int    `json:"order_item_id"`
+	ProductID    int    `json:"product_id"`
+	Quantity     int    `json:"quantity"`
+	Reason       string `json:"reason,omitempty"`
+	Condition    string `json:"condition,omitempty"`
+	RefundAmount Money  `json:"refund_amount"`
+}
+
+type Return struct {
+	ID           int          `json:"id"`
+	OrderID      int          `json:"order_id"`
+	UserID       int          `json:"user_id"`
+	Status       string       `json:"status"`
+	Reason       string       `json:"reason,omitempty"`
+	DecisionNote string       `json:"decision_note,omitempty"`
+	DecidedBy    string       `json:"decided_by,omitempty"`
+	RefundAmount Money        `json:"refund_amount"`
+	Currency     Currency     `json:"currency"`
+	CreatedAt    time.Time    `json:"created_at"`
+	DecidedAt    *time.Time   `json:"decided_at,omitempty"`
+	ReceivedAt   *time.Time   `json:"received_at,omitempty"`
+	RefundedAt   *time.Time   `json:"refunded_at,omitempty"`
+	Items        []ReturnItem `json:"items"`
+}
+
+// Review states. Only approved reviews are shown publicly; flagged ones were
+// caught by the pre-screen or reported by customers and wait for a
+// moderator like pending ones.
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+	ReviewFlagged  = "flagged"
+)
+
+// ReviewRequest is a user's review of a product. UserID comes from the
+// caller's sign-in token, never from the body; Status and ModerationNote
+// from the pre-screen.
 type ReviewRequest struct {
-	UserID    int    `json:"user_id"`
-	ProductID int    `json:"product_id"`
-	Rating    int    `json:"rating"`
-	Comment   string `json:"comment"`
+	UserID         int    `json:"-"`
+	ProductID      int    `json:"product_id"`
+	Rating         int    `json:"rating"`
+	Comment        string `json:"comment"`
+	Status         string `json:"-"`
+	ModerationNote string `json:"-"`
 }
 
 type ReviewResponse struct {
-	ID        int         `json:"id"`
-	Rating    int         `json:"rating"`
-	Comment   string      `json:"comment"`
-	CreatedAt time.Time   `json:"created_at"`
-	User      UserAccount `json:"user"`
-	Product   Product     `json:"product"`
+	ID        int        `json:"id"`
+	Rating    int        `json:"rating"`
+	Comment   string     `json:"comment"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	// VerifiedPurchase is set when the reviewer has had the product
+	// delivered.
+	VerifiedPurchase bool         `json:"verified_purchase"`
+	HelpfulCount     int          `json:"helpful_count"`
+	UnhelpfulCount   int          `json:"unhelpful_count"`
+	User             ReviewAuthor `json:"user"`
+	// Product is left out when the reviews are listed under their product.
+	Product *Product `json:"product,omitempty"`
+	// moderation details, only filled in for moderators
+	Status         string     `json:"status,omitempty"`
+	ModerationNote string     `json:"moderation_note,omitempty"`
+	ModeratedAt    *time.Time `json:"moderated_at,omitempty"`
+	ReportCount    int        `json:"report_count,omitempty"`
+}
+
+// ReviewAuthor is the public part of the reviewer's account.
+type ReviewAuthor struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+}
+
+// ReviewQuery asks for one page of a product's published reviews. Sort is
+// newest, highest, lowest or helpful; pages count from 1.
+type ReviewQuery struct {
+	ProductID int
+	Sort      string
+	Page      int
+	PageSize  int
+}
+
+// ProductReviews is a page of a product's reviews, with the product once.
+type ProductReviews struct {
+	Product  Product          `json:"product"`
+	Reviews  []ReviewResponse `json:"reviews"`
+	Sort     string           `json:"sort"`
+	Page     int              `json:"page"`
+	PageSize int              `json:"page_size"`
+	Total    int              `json:"total"`
+}
+
+type CartAbandonment struct {
+	ID           int       `json:"id"`
+	CartID       int       `json:"cart_id"`
+	UserID       *int      `json:"user_id"`
+	ItemCount    int       `json:"item_count"`
+	CartValue    Money     `json:"cart_value"`
+	LastActivity time.Time `json:"last_activity"`
+	DetectedAt   time.Time `json:"detected_at"`
+}
+
+type CartReminder struct {
+	AbandonmentID int       `json:"abandonment_id"`
+	CartID        int       `json:"cart_id"`
+	UserID        int       `json:"user_id"`
+	Username      string    `json:"username"`
+	Email         string    `json:"email"`
+	ItemCount     int       `json:"item_count"`
+	CartValue     Money     `json:"cart_value"`
+	LastActivity  time.Time `json:"last_activity"`
+}
+
+type AbandonmentReport struct {
+	Since           time.Time `json:"since"`
+	ActiveCarts     int       `json:"active_carts"`
+	AbandonedCarts  int       `json:"abandoned_carts"`
+	AbandonmentRate float64   `json:"abandonment_rate"`
+	AbandonedValue  Money     `json:"abandoned_value"`
+	RemindersSent   int       `json:"reminders_sent"`
+	Recovered       int       `json:"recovered"`
+}
+
+// IdempotencyRecord is a request made under an Idempotency-Key header and,
+// once it completed, the response it got.
+type IdempotencyRecord struct {
+	Key         string
+	Fingerprint string
+	Completed   bool
+	Status      int
+	Header      http.Header
+	Body        []byte
+	CreatedAt   time.Time
 }